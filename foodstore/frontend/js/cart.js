@@ -1,22 +1,12 @@
-const CART_KEY = "cartItems";
 const ORDER_KEY = "orderHistory";
 
-function formatPriceKZT(value) {
+const CURRENCY_SYMBOLS = { KZT: "₸", USD: "$", EUR: "€", RUB: "₽" };
+
+function formatMoney(value, currency) {
   const amount = Number(value);
   if (!Number.isFinite(amount)) return "-";
-  return `${amount.toFixed(2)} ₸`;
-}
-
-function loadCart() {
-  try {
-    return JSON.parse(localStorage.getItem(CART_KEY) || "[]");
-  } catch {
-    return [];
-  }
-}
-
-function saveCart(items) {
-  localStorage.setItem(CART_KEY, JSON.stringify(items));
+  const code = String(currency || "").toUpperCase();
+  return `${amount.toFixed(2)} ${CURRENCY_SYMBOLS[code] || code}`.trim();
 }
 
 function saveOrderToHistory(order) {
@@ -25,77 +15,142 @@ function saveOrderToHistory(order) {
   localStorage.setItem(ORDER_KEY, JSON.stringify(existing));
 }
 
+// Mirrors the server unit rules: kg is sold in 0.1 steps, pieces and packs whole.
+function quantityRule(unit) {
+  return String(unit || "").trim().toLowerCase() === "kg" ? { step: 0.1, min: 0.1 } : { step: 1, min: 1 };
+}
+
+function roundToStep(value, step) {
+  return Number((Math.floor(value / step + 1e-9) * step).toFixed(3));
+}
+
+let currentCart = { items: [], total: 0, currency: "" };
+
+const ISSUE_TEXT = {
+  price_changed: "price changed",
+  insufficient_stock: "not enough stock",
+  out_of_stock: "out of stock"
+};
+
+async function cartRequest(path, options = {}) {
+  const res = await fetch(path, {
+    ...options,
+    headers: { "Content-Type": "application/json", ...(options.headers || {}) }
+  });
+  const data = await res.json().catch(() => ({}));
+  if (!res.ok) throw new Error(data.error || "Cart request failed");
+  return data;
+}
+
+async function loadCart() {
+  try {
+    currentCart = await cartRequest("/cart");
+  } catch (err) {
+    document.getElementById("cartHint").textContent = err.message;
+    currentCart = { items: [], total: 0, currency: "" };
+  }
+  renderCart();
+}
+
+function renderIssues(item) {
+  const issues = Array.isArray(item.issues) ? item.issues : [];
+  if (!issues.length) return "";
+  const text = issues.map(issue => {
+    if (issue === "price_changed") return `price changed from ${formatMoney(item.added_price, currentCart.currency)}`;
+    if (issue === "insufficient_stock") return `only ${item.stock} in stock`;
+    return ISSUE_TEXT[issue] || issue;
+  }).join(", ");
+  return `<br><span class="hint">${escapeHtml(text)}</span>`;
+}
+
 function renderCart() {
   const rows = document.getElementById("cartRows");
-  const cart = loadCart();
+  const cart = Array.isArray(currentCart.items) ? currentCart.items : [];
   if (!cart.length) {
     rows.innerHTML = `<tr><td colspan="6" class="hint" style="padding:14px;">Cart is empty</td></tr>`;
-    document.getElementById("cartTotal").textContent = "0.00 ₸";
+    document.getElementById("cartTotal").textContent = formatMoney(0, currentCart.currency);
     document.getElementById("cartHint").textContent = "";
     return;
   }
 
-  let total = 0;
   rows.innerHTML = cart.map(item => {
-    const line = Number(item.price || 0) * Number(item.quantity || 0);
-    total += line;
-    const id = Number(item.id || 0);
-    const maxAttr = Number.isFinite(item.stock) && item.stock > 0 ? `max="${item.stock}"` : "";
+    const id = Number(item.variant_id || 0);
+    const variant = [item.variant_name, item.sku].filter(Boolean).join(" · ");
+    const rule = quantityRule(item.unit);
+    const stock = Number(item.stock);
+    const maxAttr = Number.isFinite(stock) && stock > 0 ? `max="${stock}"` : "";
     return `
       <tr>
-        <td>${id || "-"}</td>
-        <td>${escapeHtml(item.name ?? "Unnamed")}</td>
-        <td>${formatPriceKZT(item.price)}</td>
+        <td>${Number(item.product_id) || "-"}</td>
+        <td>${escapeHtml(item.name ?? "Unnamed")}${variant ? `<br><span class="hint">${escapeHtml(variant)}</span>` : ""}${renderIssues(item)}</td>
+        <td>${formatMoney(item.price, currentCart.currency)}</td>
         <td>
           <div class="qty-control">
             <button class="qty-btn" type="button" onclick="updateQty(${id}, -1)">-</button>
-            <input id="cqty-${id}" type="number" min="1" ${maxAttr} value="${item.quantity ?? 1}" oninput="clampCartQty(${id})" />
+            <input id="cqty-${id}" type="number" min="${rule.min}" step="${rule.step}" ${maxAttr} value="${item.quantity ?? rule.min}" onchange="clampCartQty(${id})" />
             <button class="qty-btn" type="button" onclick="updateQty(${id}, 1)">+</button>
           </div>
         </td>
-        <td>${formatPriceKZT(line)}</td>
+        <td>${formatMoney(item.line_total, currentCart.currency)}</td>
         <td><button class="btn" type="button" onclick="removeItem(${id})">Remove</button></td>
       </tr>
     `;
   }).join("");
-  document.getElementById("cartTotal").textContent = formatPriceKZT(total);
-  document.getElementById("cartHint").textContent = "Items: " + cart.length;
+  document.getElementById("cartTotal").textContent = formatMoney(currentCart.total, currentCart.currency);
+  let hint = currentCart.valid === false
+    ? "Some items need attention before ordering."
+    : "Items: " + cart.length;
+  if (currentCart.reserved_until) {
+    hint += " Stock held until " + new Date(currentCart.reserved_until).toLocaleTimeString() + ".";
+  }
+  document.getElementById("cartHint").textContent = hint;
 }
 
 function clampCartQty(id) {
   const input = document.getElementById(`cqty-${id}`);
   if (!input) return;
   const max = Number(input.max);
+  const min = Number(input.min) || 1;
   let val = Number(input.value);
-  if (!Number.isFinite(val) || val < 1) val = 1;
+  if (!Number.isFinite(val) || val < min) val = min;
   if (Number.isFinite(max) && max > 0 && val > max) val = max;
   input.value = val;
   updateQty(id, 0);
 }
 
-function updateQty(id, delta) {
-  const cart = loadCart();
-  const item = cart.find(x => Number(x.id) === Number(id));
+async function updateQty(id, delta) {
+  const item = (currentCart.items || []).find(x => Number(x.variant_id) === Number(id));
   if (!item) return;
   const input = document.getElementById(`cqty-${id}`);
+  const rule = quantityRule(item.unit);
   let val = Number(input ? input.value : item.quantity);
-  if (!Number.isFinite(val) || val < 1) val = 1;
-  val += delta;
-  if (Number.isFinite(item.stock) && item.stock > 0 && val > item.stock) val = item.stock;
-  if (val < 1) val = 1;
-  item.quantity = val;
-  saveCart(cart);
+  if (!Number.isFinite(val) || val < rule.min) val = rule.min;
+  val = roundToStep(val + delta * rule.step, rule.step);
+  const stock = Number(item.stock);
+  if (Number.isFinite(stock) && stock > 0 && val > stock) val = roundToStep(stock, rule.step);
+  if (val < rule.min) val = rule.min;
+  try {
+    currentCart = await cartRequest(`/cart/items/${id}`, {
+      method: "PUT",
+      body: JSON.stringify({ quantity: val })
+    });
+  } catch (err) {
+    alert(err.message);
+  }
   renderCart();
 }
 
-function removeItem(id) {
-  const cart = loadCart().filter(item => Number(item.id) !== Number(id));
-  saveCart(cart);
+async function removeItem(id) {
+  try {
+    currentCart = await cartRequest(`/cart/items/${id}`, { method: "DELETE" });
+  } catch (err) {
+    alert(err.message);
+  }
   renderCart();
 }
 
 async function placeOrderFromCart() {
-  const cart = loadCart();
+  const cart = Array.isArray(currentCart.items) ? currentCart.items : [];
   if (!cart.length) {
     alert("Cart is empty");
     return;
@@ -116,41 +171,33 @@ async function placeOrderFromCart() {
     return;
   }
 
-  const items = cart.map(item => ({
-    product_id: item.id,
-    quantity: item.quantity
-  }));
-
-  const payload = {
-    user_id,
-    delivery_address: deliveryAddress,
-    phone_number: phoneNumber,
-    comment,
-    items
-  };
-  const res = await fetch("/orders", {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    body: JSON.stringify(payload)
-  });
-  const data = await res.json().catch(() => ({}));
-  if (!res.ok) {
-    alert(data.error || "Failed to place order");
+  let data;
+  try {
+    data = await cartRequest("/cart/checkout", {
+      method: "POST",
+      body: JSON.stringify({
+        delivery_address: deliveryAddress,
+        phone_number: phoneNumber,
+        comment
+      })
+    });
+  } catch (err) {
+    alert(err.message || "Failed to place order");
+    loadCart();
     return;
   }
 
-  const total = cart.reduce((sum, item) => sum + Number(item.price || 0) * Number(item.quantity || 0), 0);
   saveOrderToHistory({
     order_id: data.order_id,
     user_id,
     items: cart,
-    total_price: total,
+    total_price: currentCart.total,
+    currency: currentCart.currency,
     delivery_address: deliveryAddress,
     phone_number: phoneNumber,
     comment,
     created_at: new Date().toISOString()
   });
-  saveCart([]);
   const addressInput = document.getElementById("orderAddress");
   const phoneInput = document.getElementById("orderPhone");
   const commentInput = document.getElementById("orderComment");
@@ -191,10 +238,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch("/api/logout", { method: "POST", keepalive: true }).catch(() => {});
   localStorage.removeItem("userToken");
   localStorage.removeItem("userEmail");
   localStorage.removeItem("userName");
   localStorage.removeItem("userRole");
+  localStorage.removeItem("userPermissions");
   localStorage.removeItem("userDate");
   localStorage.removeItem("userId");
   updateAuthButtons();
@@ -203,4 +252,5 @@ function logout() {
 
 updateAuthButtons();
 window.addEventListener("storage", updateAuthButtons);
-renderCart();
+localStorage.removeItem("cartItems");
+loadCart();
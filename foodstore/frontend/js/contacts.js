@@ -46,9 +46,7 @@ async function loadAdminMessages() {
     return;
   }
   try {
-    const res = await fetch("/contact/messages", {
-      headers: { "X-User-Id": String(userId) }
-    });
+    const res = await fetch("/contact/messages");
     const data = await res.json().catch(() => ({}));
     if (!res.ok) {
       setAdminHint(data.error || "Failed to load messages.");
@@ -93,13 +91,9 @@ function setupContactForm() {
     }
 
     try {
-      const headers = { "Content-Type": "application/json" };
-      const userId = localStorage.getItem("userId");
-      if (userId) headers["X-User-Id"] = String(userId);
-
       const res = await fetch("/contact", {
         method: "POST",
-        headers,
+        headers: { "Content-Type": "application/json" },
         body: JSON.stringify({ name, email, message })
       });
       const data = await res.json().catch(() => ({}));
@@ -118,12 +112,12 @@ function setupContactForm() {
 }
 
 function applyContactPageRoleMode() {
-  const role = localStorage.getItem("userRole") || "buyer";
+  const permissions = JSON.parse(localStorage.getItem("userPermissions") || "[]");
   const formCard = document.getElementById("contactFormCard");
   const adminCard = document.getElementById("adminMessagesCard");
   if (!formCard || !adminCard) return;
 
-  if (role === "administrator") {
+  if (permissions.includes("contact:read")) {
     formCard.style.display = "none";
     adminCard.style.display = "block";
     loadAdminMessages();
@@ -160,10 +154,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch("/api/logout", { method: "POST", keepalive: true }).catch(() => {});
   localStorage.removeItem("userToken");
   localStorage.removeItem("userEmail");
   localStorage.removeItem("userName");
   localStorage.removeItem("userRole");
+  localStorage.removeItem("userPermissions");
   localStorage.removeItem("userDate");
   localStorage.removeItem("userId");
   updateAuthButtons();
@@ -25,10 +25,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch('/api/logout', { method: 'POST', keepalive: true }).catch(() => {});
   localStorage.removeItem('userToken');
   localStorage.removeItem('userEmail');
   localStorage.removeItem('userName');
   localStorage.removeItem('userRole');
+  localStorage.removeItem('userPermissions');
   localStorage.removeItem('userDate');
   localStorage.removeItem('userId');
   updateAuthButtons();
@@ -26,10 +26,11 @@ document.getElementById('loginForm').addEventListener('submit', async (e) => {
       return;
     }
     
-    localStorage.setItem('userToken', 'token-' + Date.now());
+    localStorage.setItem('userToken', data.token);
     localStorage.setItem('userEmail', data.user.email);
     localStorage.setItem('userName', data.user.name);
     localStorage.setItem('userRole', data.user.role);
+    localStorage.setItem('userPermissions', JSON.stringify(data.user.permissions || []));
     localStorage.setItem('userDate', new Date().toLocaleDateString());
     if (data.user.id !== undefined && data.user.id !== null) {
       localStorage.setItem('userId', String(data.user.id));
@@ -1,9 +1,12 @@
 const ORDER_KEY = "orderHistory";
 
-function formatPriceKZT(value) {
+const CURRENCY_SYMBOLS = { KZT: "₸", USD: "$", EUR: "€", RUB: "₽" };
+
+function formatMoney(value, currency) {
   const amount = Number(value);
   if (!Number.isFinite(amount)) return "-";
-  return `${amount.toFixed(2)} ₸`;
+  const code = String(currency || "").toUpperCase();
+  return `${amount.toFixed(2)} ${CURRENCY_SYMBOLS[code] || code}`.trim();
 }
 
 function setOrdersHint(text) {
@@ -24,24 +27,32 @@ function renderOrders(orders) {
   const rows = document.getElementById("orderRows");
   const list = Array.isArray(orders) ? orders : JSON.parse(localStorage.getItem(ORDER_KEY) || "[]");
   if (!list.length) {
-    rows.innerHTML = `<tr><td colspan="7" class="hint" style="padding:14px;">No orders yet</td></tr>`;
+    rows.innerHTML = `<tr><td colspan="8" class="hint" style="padding:14px;">No orders yet</td></tr>`;
     return;
   }
   rows.innerHTML = list.map(order => {
     const items = (order.items || []).map(item => {
       const name = item.name ?? item.product_name ?? item.id ?? item.product_id ?? "-";
-      return `${name} x${item.quantity ?? "-"}`;
+      const variant = item.variant_name ? ` (${item.variant_name})` : "";
+      const discount = item.discount_percent ? ` (−${item.discount_percent}%)` : "";
+      return `${name}${variant} x${item.quantity ?? "-"}${discount}`;
     }).join(", ");
     const created = order.created_at ? new Date(order.created_at).toLocaleString() : "-";
+    const orderId = order.order_id ?? order.id;
+    const canCancel = order.status === "pending" || order.status === "confirmed";
+    const cancelBtn = canCancel
+      ? `<br><button class="btn danger" type="button" onclick="cancelOrder(${orderId})">Cancel</button>`
+      : "";
     return `
       <tr>
         <td>${order.order_id ?? order.id ?? "-"}</td>
         <td>${escapeHtml(items || "-")}</td>
-        <td>${formatPriceKZT(order.total_price)}</td>
+        <td>${formatMoney(order.total_price, order.currency)}</td>
         <td>${escapeHtml(order.delivery_address || "-")}</td>
         <td>${escapeHtml(order.phone_number || "-")}</td>
         <td>${escapeHtml(order.comment || "-")}</td>
         <td>${escapeHtml(created)}</td>
+        <td>${escapeHtml(order.status || "-")}${renderFulfillments(order.fulfillments)}${cancelBtn}</td>
       </tr>
     `;
   }).join("");
@@ -58,7 +69,12 @@ async function loadOrders() {
   }
 
   try {
-    const res = await fetch(`/orders?user_id=${userId}`);
+    const res = await fetch("/orders");
+    if (res.status === 401) {
+      setOrdersHint("Session expired. Please log in again.");
+      renderOrders();
+      return;
+    }
     if (!res.ok) {
       setOrdersHint("Could not load orders from server. Showing local history.");
       renderOrders();
@@ -74,6 +90,34 @@ async function loadOrders() {
   }
 }
 
+function renderFulfillments(fulfillments) {
+  const list = Array.isArray(fulfillments) ? fulfillments : [];
+  if (list.length < 2) return "";
+  return list.map(f =>
+    `<br><span class="hint">${escapeHtml(f.seller_name || "Seller")}: ${escapeHtml(f.status || "-")}</span>`
+  ).join("");
+}
+
+async function cancelOrder(orderId) {
+  const reason = prompt("Why are you cancelling this order? (optional)");
+  if (reason === null) return;
+  try {
+    const res = await fetch(`/orders/${orderId}/cancel`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ reason })
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      alert(data.error || "Failed to cancel order");
+      return;
+    }
+    await loadOrders();
+  } catch {
+    alert("Failed to cancel order");
+  }
+}
+
 function escapeHtml(s){
   return String(s).replaceAll("&","&amp;").replaceAll("<","&lt;").replaceAll(">","&gt;");
 }
@@ -105,10 +149,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch('/api/logout', { method: 'POST', keepalive: true }).catch(() => {});
   localStorage.removeItem('userToken');
   localStorage.removeItem('userEmail');
   localStorage.removeItem('userName');
   localStorage.removeItem('userRole');
+  localStorage.removeItem('userPermissions');
   localStorage.removeItem('userDate');
   localStorage.removeItem('userId');
   updateAuthButtons();
@@ -1,18 +1,43 @@
 let all = [];
-const CART_KEY = "cartItems";
+let nextOffset = 0;
+let hasMore = false;
+const PAGE_SIZE = 24;
+// selectedVariants maps a product id to the variant id picked on its card.
+const selectedVariants = {};
+
+function searchText() {
+  return (document.getElementById("q")?.value || "").trim();
+}
+
+function catalogQuery(offset) {
+  const params = new URLSearchParams({ limit: String(PAGE_SIZE), offset: String(offset) });
+  const q = searchText();
+  if (q) params.set("q", q);
+  const sort = document.getElementById("sort")?.value || "";
+  if (sort) params.set("sort", sort);
+  if (document.getElementById("inStock")?.checked) params.set("in_stock", "1");
+  return params.toString();
+}
 
-async function loadProducts() {
+// loadProducts fetches the first page, or the next one when append is set.
+async function loadProducts(append = false) {
+  const offset = append ? nextOffset : 0;
   try {
-    const res = await fetch("/products");
+    const path = searchText() ? "/products/search" : "/products";
+    const res = await fetch(`${path}?${catalogQuery(offset)}`);
     if (!res.ok) {
       const text = await res.text().catch(() => "");
       throw new Error(text || `Failed to load products (${res.status})`);
     }
     const data = await res.json();
-    all = Array.isArray(data) ? data : [];
+    const items = Array.isArray(data.items) ? data.items : [];
+    all = append ? all.concat(items) : items;
+    nextOffset = offset + items.length;
+    hasMore = Boolean(data.has_more);
     render();
   } catch (err) {
-    all = [];
+    if (!append) all = [];
+    hasMore = false;
     render();
     console.error(err);
   }
@@ -43,8 +68,11 @@ function formatPrice(value) {
   return Number.isFinite(n) ? n.toFixed(2) : "0.00";
 }
 
-function formatPriceWithUnit(value, unit) {
-  return `${formatPrice(value)} TG/${formatUnit(unit)}`;
+const CURRENCY_SYMBOLS = { KZT: "₸", USD: "$", EUR: "€", RUB: "₽" };
+
+function formatPriceWithUnit(value, currency, unit) {
+  const code = String(currency || "").toUpperCase();
+  return `${formatPrice(value)} ${CURRENCY_SYMBOLS[code] || code}/${formatUnit(unit)}`;
 }
 
 function formatUnit(value) {
@@ -54,25 +82,69 @@ function formatUnit(value) {
   return "piece";
 }
 
+// Mirrors the server unit rules: kg is sold in 0.1 steps, pieces and packs whole.
+function quantityRule(unit) {
+  return formatUnit(unit) === "kg" ? { step: 0.1, min: 0.1 } : { step: 1, min: 1 };
+}
+
+function roundToStep(value, step) {
+  return Number((Math.floor(value / step + 1e-9) * step).toFixed(3));
+}
+
 function render() {
-  const q = (document.getElementById("q")?.value || "").toLowerCase().trim();
-  const list = q
-    ? all.filter(p => `${p.name || ""} ${p.category || ""}`.toLowerCase().includes(q))
-    : all;
+  const list = all;
 
   const rows = document.getElementById("rows");
   rows.innerHTML = list.map(renderCard).join("") || `<div class="card hint" style="margin-top:12px;">No products yet.</div>`;
+  const more = document.getElementById("loadMore");
+  if (more) more.style.display = hasMore ? "" : "none";
+}
+
+// available is the stock minus active checkout holds.
+function availableStock(product) {
+  const value = Number(product.available ?? product.stock);
+  return Number.isFinite(value) ? value : 0;
+}
+
+// currentVariant returns the variant picked on a product card, the first one
+// by default. Products listed without variants stand for their only variant.
+function currentVariant(product) {
+  const variants = Array.isArray(product.variants) ? product.variants : [];
+  const picked = variants.find(v => Number(v.id) === Number(selectedVariants[product.id]));
+  return picked || variants[0] || product;
+}
+
+function selectVariant(productID, variantID) {
+  selectedVariants[productID] = Number(variantID);
+  render();
+}
+
+function variantLabel(variant) {
+  const name = String(variant.name || "").trim();
+  return name ? `${name} (${variant.sku})` : String(variant.sku || `#${variant.id}`);
+}
+
+function renderVariantSelect(product, variant) {
+  const variants = Array.isArray(product.variants) ? product.variants : [];
+  if (variants.length < 2) return "";
+  const id = Number(product.id) || 0;
+  return `
+    <select class="variant-select" onchange="selectVariant(${id}, this.value)">
+      ${variants.map(v => `<option value="${Number(v.id)}" ${Number(v.id) === Number(variant.id) ? "selected" : ""}>${escapeHtml(variantLabel(v))}</option>`).join("")}
+    </select>
+  `;
 }
 
 function renderCard(product) {
   const id = Number(product.id) || 0;
-  const stock = Number.isFinite(Number(product.stock)) ? Number(product.stock) : 0;
+  const variant = currentVariant(product);
+  const stock = availableStock(variant);
   const userID = Number(localStorage.getItem("userId") || 0);
   const isOwnProduct = userID > 0 && Number(product.seller_id) === userID;
   const outOfStock = stock <= 0;
   const buyingBlocked = outOfStock || isOwnProduct;
   const imageSrc = productImageSrc(product);
-  const unit = formatUnit(product.unit);
+  const unit = formatUnit(variant.unit);
 
   return `
     <article class="product-card">
@@ -84,9 +156,11 @@ function renderCard(product) {
         </div>
 
         <p class="product-desc">${escapeHtml(product.description || "-")}</p>
+        ${renderVariantSelect(product, variant)}
 
         <div class="product-meta">
-          <span class="product-price">${formatPriceWithUnit(product.price, unit)}</span>
+          <span class="product-price">${formatPriceWithUnit(variant.display_price ?? variant.price, product.display_currency || product.currency, unit)}</span>
+          ${variant.discount_price ? `<span class="product-stock danger" title="Best before soon">−${Number(variant.discount_percent) || 0}%: ${formatPriceWithUnit(variant.display_discount_price ?? variant.discount_price, product.display_currency || product.currency, unit)} for ${variant.discounted} ${unit}</span>` : ""}
           <span class="product-stock ${outOfStock ? "danger" : ""}">Stock: ${stock} ${unit}</span>
           <span class="product-id">ID: ${id || "-"}</span>
         </div>
@@ -95,7 +169,7 @@ function renderCard(product) {
         <div class="product-actions">
           <div class="qty-control">
             <button class="qty-btn" type="button" onclick="stepQty(${id}, -1)" ${buyingBlocked ? "disabled" : ""}>-</button>
-            <input id="qty-${id}" type="number" min="0" ${stock > 0 ? `max="${stock}"` : ""} value="0" oninput="clampQty(${id})" ${buyingBlocked ? "disabled" : ""} />
+            <input id="qty-${id}" type="number" min="0" step="${quantityRule(variant.unit).step}" ${stock > 0 ? `max="${stock}"` : ""} value="0" oninput="clampQty(${id})" ${buyingBlocked ? "disabled" : ""} />
             <button class="qty-btn" type="button" onclick="stepQty(${id}, 1)" ${buyingBlocked ? "disabled" : ""}>+</button>
           </div>
           <button class="btn" type="button" onclick="addToCart(${id})" ${buyingBlocked ? "disabled" : ""}>Add to Cart</button>
@@ -105,36 +179,26 @@ function renderCard(product) {
   `;
 }
 
-function loadCart() {
-  try {
-    return JSON.parse(localStorage.getItem(CART_KEY) || "[]");
-  } catch {
-    return [];
-  }
-}
-
-function saveCart(items) {
-  localStorage.setItem(CART_KEY, JSON.stringify(items));
-}
-
 function clampQty(id) {
   const input = document.getElementById(`qty-${id}`);
   if (!input) return;
   const max = Number(input.max);
+  const step = Number(input.step) || 1;
   let val = Number(input.value);
   if (!Number.isFinite(val) || val < 0) val = 0;
   if (Number.isFinite(max) && max > 0 && val > max) val = max;
-  input.value = val;
+  input.value = roundToStep(val, step);
 }
 
 function stepQty(id, delta) {
   const input = document.getElementById(`qty-${id}`);
   if (!input) return;
-  input.value = Number(input.value || 1) + delta;
+  const step = Number(input.step) || 1;
+  input.value = Number(input.value || 0) + delta * step;
   clampQty(id);
 }
 
-function addToCart(id) {
+async function addToCart(id) {
   const product = all.find(p => Number(p.id) === Number(id));
   if (!product) return;
   const userID = Number(localStorage.getItem("userId") || 0);
@@ -143,11 +207,14 @@ function addToCart(id) {
     return;
   }
 
+  const variant = currentVariant(product);
   const input = document.getElementById(`qty-${id}`);
+  const rule = quantityRule(variant.unit);
   const rawQty = input ? Number(input.value) : 0;
-  const qty = Number.isFinite(rawQty) ? Math.floor(rawQty) : 0;
+  const qty = Number.isFinite(rawQty) ? roundToStep(rawQty, rule.step) : 0;
 
-  if (Number.isFinite(Number(product.stock)) && Number(product.stock) <= 0) {
+  const available = availableStock(variant);
+  if (available <= 0) {
     alert("Out of stock");
     return;
   }
@@ -155,32 +222,25 @@ function addToCart(id) {
     alert("Select quantity greater than 0");
     return;
   }
-  if (Number.isFinite(Number(product.stock)) && qty > Number(product.stock)) {
+  if (qty < rule.min) {
+    alert(`Minimum quantity is ${rule.min} ${formatUnit(variant.unit)}`);
+    return;
+  }
+  if (qty > available) {
     alert("Not enough stock");
     return;
   }
 
-  const cart = loadCart();
-  const existing = cart.find(item => Number(item.id) === Number(id));
-  if (existing) {
-    existing.quantity += qty;
-    if (Number.isFinite(Number(product.stock)) && existing.quantity > Number(product.stock)) {
-      existing.quantity = Number(product.stock);
-    }
-  } else {
-    cart.push({
-      id: product.id,
-      name: product.name,
-      description: product.description,
-      image_url: product.image_url,
-      category: product.category,
-      unit: formatUnit(product.unit),
-      price: product.price,
-      stock: product.stock,
-      quantity: qty
-    });
+  const res = await fetch("/cart/items", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ product_id: Number(product.id), variant_id: variant === product ? 0 : Number(variant.id), quantity: qty })
+  });
+  if (!res.ok) {
+    const data = await res.json().catch(() => ({}));
+    alert(data.error || "Failed to add to cart");
+    return;
   }
-  saveCart(cart);
   alert("Added to cart");
 }
 
@@ -221,9 +281,10 @@ function updateAuthButtons() {
     userNameSpan.style.display = "inline";
     logoutBtn.style.display = "inline-block";
     profileBtn.style.display = "inline-block";
-    const canManageProducts = userRole === "seller" || userRole === "administrator";
+    const permissions = JSON.parse(localStorage.getItem("userPermissions") || "[]");
+    const canManageProducts = permissions.includes("product:write:own");
     sellerProductsBtn.style.display = canManageProducts ? "inline-flex" : "none";
-    sellerOrdersBtn.style.display = userRole === "seller" ? "inline-flex" : "none";
+    sellerOrdersBtn.style.display = permissions.includes("order:read:seller") ? "inline-flex" : "none";
     createProductBtn.style.display = canManageProducts ? "inline-flex" : "none";
     userNameSpan.textContent = userName || "User";
   } else {
@@ -239,10 +300,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch("/api/logout", { method: "POST", keepalive: true }).catch(() => {});
   localStorage.removeItem("userToken");
   localStorage.removeItem("userEmail");
   localStorage.removeItem("userName");
   localStorage.removeItem("userRole");
+  localStorage.removeItem("userPermissions");
   localStorage.removeItem("userDate");
   localStorage.removeItem("userId");
   updateAuthButtons();
@@ -252,7 +315,11 @@ function logout() {
 function initProductsPage() {
   const searchInput = document.getElementById("q");
   if (searchInput) {
-    searchInput.addEventListener("input", render);
+    let timer = null;
+    searchInput.addEventListener("input", () => {
+      clearTimeout(timer);
+      timer = setTimeout(() => loadProducts(), 250);
+    });
   }
   updateAuthButtons();
   loadProducts();
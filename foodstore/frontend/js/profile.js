@@ -15,10 +15,12 @@ document.getElementById('profileDated').textContent = userDate;
 document.getElementById('userName').textContent = userName;
 
 function logout() {
+  fetch('/api/logout', { method: 'POST', keepalive: true }).catch(() => {});
   localStorage.removeItem('userToken');
   localStorage.removeItem('userEmail');
   localStorage.removeItem('userName');
   localStorage.removeItem('userRole');
+  localStorage.removeItem('userPermissions');
   localStorage.removeItem('userDate');
   localStorage.removeItem('userId');
   window.location.href = '/';
@@ -37,10 +37,11 @@ document.getElementById('registerForm').addEventListener('submit', async (e) =>
       return;
     }
     
-    localStorage.setItem('userToken', 'token-' + data.user_id);
+    localStorage.setItem('userToken', data.token);
     localStorage.setItem('userEmail', email);
     localStorage.setItem('userName', name);
     localStorage.setItem('userRole', data.role || role || 'buyer');
+    localStorage.setItem('userPermissions', JSON.stringify(data.permissions || []));
     localStorage.setItem('userDate', new Date().toLocaleDateString());
     if (data.user_id !== undefined && data.user_id !== null) {
       localStorage.setItem('userId', String(data.user_id));
@@ -1,7 +1,10 @@
-function formatPriceKZT(value) {
+const CURRENCY_SYMBOLS = { KZT: "₸", USD: "$", EUR: "€", RUB: "₽" };
+
+function formatMoney(value, currency) {
   const amount = Number(value);
   if (!Number.isFinite(amount)) return "-";
-  return `${amount.toFixed(2)} ₸`;
+  const code = String(currency || "").toUpperCase();
+  return `${amount.toFixed(2)} ${CURRENCY_SYMBOLS[code] || code}`.trim();
 }
 
 function escapeHtml(s) {
@@ -19,37 +22,45 @@ function renderOrders(orders) {
 
   const list = Array.isArray(orders) ? orders : [];
   if (!list.length) {
-    rows.innerHTML = `<tr><td colspan="8" class="hint" style="padding:14px;">No incoming orders yet.</td></tr>`;
+    rows.innerHTML = `<tr><td colspan="9" class="hint" style="padding:14px;">No incoming orders yet.</td></tr>`;
     return;
   }
 
   rows.innerHTML = list.map(order => {
     const buyer = `${escapeHtml(order.buyer_name || "Unknown")}<br><span class="hint">${escapeHtml(order.buyer_email || "-")}</span>`;
     const items = (order.items || []).map(item =>
-      `${escapeHtml(item.name || item.product_name || "-")} x${item.quantity ?? "-"} (${formatPriceKZT(item.line_total)})`
+      `${escapeHtml(item.name || item.product_name || "-")}${item.variant_name ? ` ${escapeHtml(item.variant_name)}` : ""}${item.sku ? ` <span class="hint">${escapeHtml(item.sku)}</span>` : ""} x${item.quantity ?? "-"}${item.discount_percent ? ` −${item.discount_percent}%` : ""} (${formatMoney(item.line_total, order.currency)})`
     ).join("<br>");
     const created = order.created_at ? new Date(order.created_at).toLocaleString() : "-";
+    const actions = (order.next_statuses || []).map(status =>
+      `<button class="btn${status === "cancelled" ? " danger" : ""}" type="button" onclick="updateOrderStatus(${order.id}, '${status}')">${escapeHtml(status)}</button>`
+    ).join(" ");
     return `
       <tr>
         <td>${order.id ?? "-"}</td>
         <td>${buyer}</td>
         <td>${items || "-"}</td>
-        <td>${formatPriceKZT(order.seller_total)}</td>
+        <td>${formatMoney(order.seller_total, order.currency)}</td>
         <td>${escapeHtml(order.delivery_address || "-")}</td>
         <td>${escapeHtml(order.phone_number || "-")}</td>
         <td>${escapeHtml(order.comment || "-")}</td>
         <td>${escapeHtml(created)}</td>
+        <td>
+          <strong>${escapeHtml(order.status || "-")}</strong>
+          <br><span class="hint">Order: ${escapeHtml(order.order_status || "-")}</span>
+          ${actions ? `<br>${actions}` : ""}
+        </td>
       </tr>
     `;
   }).join("");
 }
 
 function ensureSellerAccess() {
-  const role = localStorage.getItem("userRole") || "buyer";
+  const permissions = JSON.parse(localStorage.getItem("userPermissions") || "[]");
   const notice = document.getElementById("sellerOnlyNotice");
   const panel = document.getElementById("sellerOrdersPanel");
 
-  if (role !== "seller") {
+  if (!permissions.includes("order:read:seller")) {
     if (notice) notice.style.display = "block";
     if (panel) panel.style.display = "none";
     return false;
@@ -69,9 +80,7 @@ async function loadSellerOrders() {
   }
 
   try {
-    const res = await fetch("/seller/orders", {
-      headers: { "X-User-Id": String(userId) }
-    });
+    const res = await fetch("/seller/orders");
     const data = await res.json().catch(() => ({}));
     if (!res.ok) {
       setHint(data.error || "Failed to load seller orders.");
@@ -89,6 +98,28 @@ async function loadSellerOrders() {
   }
 }
 
+async function updateOrderStatus(orderId, status) {
+  if (status === "cancelled" && !confirm("Cancel this order? Stock will be returned.")) {
+    return;
+  }
+  try {
+    const res = await fetch(`/orders/${orderId}/status`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ status })
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      alert(data.error || "Failed to update order status");
+      return;
+    }
+    await loadSellerOrders();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to update order status");
+  }
+}
+
 function updateAuthButtons() {
   const userToken = localStorage.getItem("userToken");
   const userName = localStorage.getItem("userName");
@@ -119,10 +150,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch("/api/logout", { method: "POST", keepalive: true }).catch(() => {});
   localStorage.removeItem("userToken");
   localStorage.removeItem("userEmail");
   localStorage.removeItem("userName");
   localStorage.removeItem("userRole");
+  localStorage.removeItem("userPermissions");
   localStorage.removeItem("userDate");
   localStorage.removeItem("userId");
   updateAuthButtons();
@@ -1,12 +1,12 @@
 let mine = [];
 
-function canManageProductsRole(role) {
-  return role === "seller" || role === "administrator";
+function hasPermission(permission) {
+  const permissions = JSON.parse(localStorage.getItem("userPermissions") || "[]");
+  return permissions.includes(permission);
 }
 
 async function loadMyProducts() {
   const userId = localStorage.getItem("userId");
-  const role = localStorage.getItem("userRole") || "buyer";
   if (!userId) {
     mine = [];
     render();
@@ -14,15 +14,20 @@ async function loadMyProducts() {
   }
 
   try {
-    const endpoint = role === "administrator" ? "/products" : "/products?mine=1";
-    const res = await fetch(endpoint, {
-      headers: { "X-User-Id": String(userId) }
-    });
-    const data = await res.json().catch(() => ({}));
-    if (!res.ok) {
-      throw new Error(data.error || `Failed to load products (${res.status})`);
+    const endpoint = hasPermission("product:write:any") ? "/products?limit=100" : "/products?mine=1&limit=100";
+    const items = [];
+    for (let offset = 0; ; ) {
+      const res = await fetch(`${endpoint}&offset=${offset}`);
+      const data = await res.json().catch(() => ({}));
+      if (!res.ok) {
+        throw new Error(data.error || `Failed to load products (${res.status})`);
+      }
+      const page = Array.isArray(data.items) ? data.items : [];
+      items.push(...page);
+      offset += page.length;
+      if (!data.has_more || !page.length) break;
     }
-    mine = Array.isArray(data) ? data : [];
+    mine = items;
     render();
   } catch (err) {
     console.error(err);
@@ -64,8 +69,11 @@ function formatPrice(value) {
   return Number.isFinite(n) ? n.toFixed(2) : "0.00";
 }
 
-function formatPriceWithUnit(value, unit) {
-  return `${formatPrice(value)} TG/${formatUnit(unit)}`;
+const CURRENCY_SYMBOLS = { KZT: "₸", USD: "$", EUR: "€", RUB: "₽" };
+
+function formatPriceWithUnit(value, currency, unit) {
+  const code = String(currency || "").toUpperCase();
+  return `${formatPrice(value)} ${CURRENCY_SYMBOLS[code] || code}/${formatUnit(unit)}`;
 }
 
 function formatUnit(value) {
@@ -99,6 +107,14 @@ async function createProduct() {
   const unit = formatUnit(document.getElementById("p_unit").value);
   const price = Number(document.getElementById("p_price").value);
   const stock = Number(document.getElementById("p_stock").value);
+  const sku = document.getElementById("p_sku").value.trim();
+  const barcode = document.getElementById("p_barcode").value.trim();
+  const reorderThreshold = document.getElementById("p_reorder").value.trim();
+  const expiryFields = {
+    expiry_hide_days: document.getElementById("p_hide_days").value.trim(),
+    expiry_discount_days: document.getElementById("p_discount_days").value.trim(),
+    expiry_discount_percent: document.getElementById("p_discount_percent").value.trim()
+  };
   const imageFile = document.getElementById("p_image").files?.[0];
 
   if (!name || !description || !category || !Number.isFinite(price) || !Number.isFinite(stock)) {
@@ -117,12 +133,19 @@ async function createProduct() {
   form.append("unit", unit);
   form.append("price", String(price));
   form.append("stock", String(stock));
+  form.append("sku", sku);
+  form.append("barcode", barcode);
+  if (reorderThreshold) {
+    form.append("reorder_threshold", reorderThreshold);
+  }
+  Object.entries(expiryFields).forEach(([field, value]) => {
+    if (value) form.append(field, value);
+  });
   form.append("image", imageFile);
 
   try {
     const res = await fetch("/products", {
       method: "POST",
-      headers: { "X-User-Id": String(userId) },
       body: form
     });
 
@@ -139,6 +162,12 @@ async function createProduct() {
     document.getElementById("p_unit").value = "piece";
     document.getElementById("p_price").value = "";
     document.getElementById("p_stock").value = "";
+    document.getElementById("p_sku").value = "";
+    document.getElementById("p_barcode").value = "";
+    document.getElementById("p_reorder").value = "";
+    document.getElementById("p_hide_days").value = "";
+    document.getElementById("p_discount_days").value = "";
+    document.getElementById("p_discount_percent").value = "";
     document.getElementById("p_image").value = "";
 
     await loadMyProducts();
@@ -151,18 +180,25 @@ async function createProduct() {
 function render() {
   const q = (document.getElementById("q")?.value || "").toLowerCase().trim();
   const list = q
-    ? mine.filter(p => `${p.name || ""} ${p.category || ""}`.toLowerCase().includes(q))
+    ? mine.filter(p => `${p.name || ""} ${p.category || ""} ${(p.variants || []).map(v => v.sku).join(" ")}`.toLowerCase().includes(q))
     : mine;
 
   const rows = document.getElementById("rows");
   rows.innerHTML = list.map(renderCard).join("") || `<div class="card hint" style="margin-top:12px;">You have no products yet.</div>`;
 }
 
+// The product form edits the first (default) variant; the variants section
+// below it manages every variant of the product.
 function renderCard(product) {
   const id = Number(product.id) || 0;
+  const variants = Array.isArray(product.variants) ? product.variants : [];
+  const base = variants[0] || product;
   const imageSrc = productImageSrc(product);
   const unit = formatUnit(product.unit);
   const stock = Number.isFinite(Number(product.stock)) ? Number(product.stock) : 0;
+  const available = Number.isFinite(Number(product.available)) ? Number(product.available) : stock;
+  const heldText = available < stock ? ` (${available} available)` : "";
+  const expiry = product.expiry || {};
 
   return `
     <article class="product-card">
@@ -176,8 +212,8 @@ function renderCard(product) {
         <p class="product-desc">${escapeHtml(product.description || "-")}</p>
 
         <div class="product-meta">
-          <span class="product-price">${formatPriceWithUnit(product.price, unit)}</span>
-          <span class="product-stock ${stock <= 0 ? "danger" : ""}">Stock: ${stock} ${unit}</span>
+          <span class="product-price">${variants.length > 1 ? "from " : ""}${formatPriceWithUnit(product.price, product.currency, unit)}</span>
+          <span class="product-stock ${stock <= 0 ? "danger" : ""}">Stock: ${stock} ${unit}${heldText}</span>
           <span class="product-id">ID: ${id || "-"}</span>
         </div>
 
@@ -185,22 +221,365 @@ function renderCard(product) {
           <div class="seller-edit-grid">
             <div class="field"><input id="edit-name-${id}" value="${escapeAttr(product.name || "")}" placeholder="Name" /></div>
             <div class="field"><input id="edit-desc-${id}" value="${escapeAttr(product.description || "")}" placeholder="Description" /></div>
-            <div class="field"><input id="edit-cat-${id}" value="${escapeAttr(product.category || "")}" placeholder="Category" /></div>
-            <div class="field"><select id="edit-unit-${id}">${renderUnitOptions(product.unit)}</select></div>
-            <div class="field"><input id="edit-price-${id}" type="number" min="0" step="0.01" value="${formatPrice(product.price)}" placeholder="Price" /></div>
-            <div class="field"><input id="edit-stock-${id}" type="number" min="0" value="${stock}" placeholder="Stock" /></div>
+            <div class="field"><input id="edit-cat-${id}" value="${escapeAttr(product.category || "")}" list="categoryOptions" placeholder="Category" /></div>
+            <div class="field"><select id="edit-unit-${id}">${renderUnitOptions(base.unit)}</select></div>
+            <div class="field"><input id="edit-price-${id}" type="number" min="0" step="0.01" value="${formatPrice(base.price)}" placeholder="Price" /></div>
+            <div class="field"><input id="edit-sku-${id}" value="${escapeAttr(base.sku || "")}" placeholder="SKU" /></div>
+            <div class="field"><input id="edit-reorder-${id}" type="number" min="0" step="0.1" value="${Number(product.reorder_threshold) || 0}" placeholder="Reorder threshold" title="Reorder threshold (0 turns low-stock alerts off)" /></div>
+            <div class="field"><input id="edit-hide-${id}" type="number" min="0" max="365" step="1" value="${Number(expiry.hide_days) || 0}" placeholder="Hide days before expiry" title="Hide batches this many days before their best-before date" /></div>
+            <div class="field"><input id="edit-discdays-${id}" type="number" min="0" max="365" step="1" value="${Number(expiry.discount_days) || 0}" placeholder="Discount days before expiry" title="Discount batches this many days before their best-before date" /></div>
+            <div class="field"><input id="edit-discpct-${id}" type="number" min="0" max="90" step="1" value="${Number(expiry.discount_percent) || 0}" placeholder="Expiry discount %" title="Discount on near-expiry batches, percent (0 turns it off)" /></div>
             <div class="field"><input id="edit-img-${id}" type="file" accept="image/*" /></div>
           </div>
           <div class="seller-edit-actions">
             <button class="btn" type="button" onclick="saveProductRow(${id})">Save</button>
-            <button class="btn danger" type="button" onclick="deleteProduct(${id})">Delete</button>
+            <button class="btn danger" type="button" onclick="deleteProduct(${id})">Archive</button>
+          </div>
+        </div>
+
+        <div class="seller-variants">
+          <div class="hint">Variants</div>
+          ${variants.map(v => renderVariantRow(id, v)).join("")}
+          ${renderVariantRow(id, null)}
+        </div>
+
+        <div class="seller-variants">
+          <div class="hint">Stock movements</div>
+          <div class="seller-variant-row">
+            <div class="field"><select id="mv-kind-${id}">
+              <option value="receipt">receipt</option>
+              <option value="adjustment">adjustment (±)</option>
+              <option value="spoilage">spoilage</option>
+            </select></div>
+            <div class="field"><select id="mv-variant-${id}">${variants.map(v => `<option value="${Number(v.id)}">${escapeHtml(v.name || v.sku || `#${v.id}`)}</option>`).join("")}</select></div>
+            <div class="field"><input id="mv-qty-${id}" type="number" step="0.1" placeholder="Quantity" /></div>
+            <div class="field"><input id="mv-lot-${id}" placeholder="Lot number (receipts)" /></div>
+            <div class="field"><input id="mv-best-${id}" type="date" title="Best before (new lots)" /></div>
+            <div class="field" style="grid-column:span 3;"><input id="mv-reason-${id}" placeholder="Reason" /></div>
+            <div class="seller-edit-actions" style="margin-top:0;">
+              <button class="btn" type="button" onclick="recordMovement(${id})">Record</button>
+              <button class="btn" type="button" onclick="loadMovements(${id})">History</button>
+              <button class="btn" type="button" onclick="loadBatches(${id})">Batches</button>
+            </div>
           </div>
+          <div id="bt-list-${id}"></div>
+          <div id="mv-list-${id}"></div>
         </div>
       </div>
     </article>
   `;
 }
 
+async function recordMovement(productID) {
+  const quantity = Number(document.getElementById(`mv-qty-${productID}`)?.value);
+  if (!Number.isFinite(quantity) || quantity === 0) {
+    alert("Enter a quantity");
+    return;
+  }
+  const body = {
+    variant_id: Number(document.getElementById(`mv-variant-${productID}`)?.value) || 0,
+    kind: document.getElementById(`mv-kind-${productID}`)?.value || "receipt",
+    quantity,
+    reason: document.getElementById(`mv-reason-${productID}`)?.value.trim() || "",
+    lot_number: document.getElementById(`mv-lot-${productID}`)?.value.trim() || "",
+    best_before: document.getElementById(`mv-best-${productID}`)?.value || ""
+  };
+
+  try {
+    const res = await fetch(`/products/${productID}/stock-movements`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body)
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      alert(data.error || "Failed to record movement");
+      return;
+    }
+
+    await loadMyProducts();
+    await loadMovements(productID);
+    await loadBatches(productID);
+    await loadExpiring();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to record movement");
+  }
+}
+
+// loadMovements shows the latest stock movements of a product with the
+// totals by kind.
+async function loadMovements(productID) {
+  const box = document.getElementById(`mv-list-${productID}`);
+  if (!box) return;
+  try {
+    const res = await fetch(`/products/${productID}/stock-movements?limit=20`);
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      box.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to load movements")}</div>`;
+      return;
+    }
+    const totals = Object.entries(data.totals || {}).map(([kind, qty]) => `${escapeHtml(kind)}: ${qty}`).join(", ");
+    const rows = (data.items || []).map(m => `
+      <div class="hint">
+        ${new Date(m.created_at).toLocaleString()} · ${escapeHtml(m.sku)}${m.lot_number ? ` · lot ${escapeHtml(m.lot_number)}` : ""} · ${escapeHtml(m.kind)} ${m.quantity > 0 ? "+" : ""}${m.quantity} → ${m.stock_after}
+        ${m.order_id ? ` · order #${m.order_id}` : ""}${m.actor_name ? ` · ${escapeHtml(m.actor_name)}` : ""}${m.reason ? ` · ${escapeHtml(m.reason)}` : ""}
+      </div>
+    `).join("");
+    box.innerHTML = `<div class="hint" style="margin-top:6px;">${totals || "No movements yet."}</div>${rows}`;
+  } catch (err) {
+    console.error(err);
+    box.innerHTML = `<div class="hint danger">Failed to load movements</div>`;
+  }
+}
+
+// batchStatusText labels the status the product's expiry policy gives a
+// batch.
+function batchStatusText(status) {
+  switch (status) {
+    case "discounted": return "Discounted";
+    case "hidden": return "Hidden";
+    case "expired": return "Expired";
+    default: return "On sale";
+  }
+}
+
+function renderBatch(b, withProduct) {
+  const bestBefore = b.best_before ? `best before ${escapeHtml(b.best_before)}` : "no best-before date";
+  const daysLeft = b.days_left === null || b.days_left === undefined ? "" : ` (${b.days_left} d)`;
+  return `
+    <div class="seller-alert">
+      <span class="product-stock ${b.status === "on_sale" ? "" : "danger"}">${batchStatusText(b.status)}</span>
+      <span>${withProduct ? `${escapeHtml(b.product_name)} · ` : ""}${escapeHtml(b.variant_name || b.sku)} · lot ${escapeHtml(b.lot_number || "-")}</span>
+      <span class="hint">${b.quantity} ${formatUnit(b.unit)} · ${bestBefore}${daysLeft}</span>
+    </div>
+  `;
+}
+
+// loadBatches shows the lots of a product that hold stock, in the order
+// sales take them.
+async function loadBatches(productID) {
+  const box = document.getElementById(`bt-list-${productID}`);
+  if (!box) return;
+  try {
+    const res = await fetch(`/products/${productID}/batches`);
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      box.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to load batches")}</div>`;
+      return;
+    }
+    const rows = (Array.isArray(data) ? data : []).map(b => renderBatch(b, false)).join("");
+    box.innerHTML = rows || `<div class="hint" style="margin-top:6px;">No batches in stock.</div>`;
+  } catch (err) {
+    console.error(err);
+    box.innerHTML = `<div class="hint danger">Failed to load batches</div>`;
+  }
+}
+
+// loadExpiring shows the seller's stock expiring within the chosen number
+// of days.
+async function loadExpiring() {
+  const box = document.getElementById("expiring");
+  if (!box) return;
+  const days = document.getElementById("expiringDays")?.value.trim() || "";
+  try {
+    const res = await fetch(`/seller/expiring${days ? `?days=${encodeURIComponent(days)}` : ""}`);
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      box.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to load expiring stock")}</div>`;
+      return;
+    }
+    const rows = (data.items || []).map(b => renderBatch(b, true)).join("");
+    box.innerHTML = rows || `<div class="hint" style="margin-top:8px;">Nothing expires in the next ${Number(data.days) || 0} days.</div>`;
+  } catch (err) {
+    console.error(err);
+    box.innerHTML = `<div class="hint danger">Failed to load expiring stock</div>`;
+  }
+}
+
+// renderVariantRow renders the inputs of one variant, or of a new variant
+// when variant is null.
+function renderVariantRow(productID, variant) {
+  const key = variant ? `${productID}-${Number(variant.id)}` : `${productID}-new`;
+  const v = variant || {};
+  const actions = variant
+    ? `<button class="btn" type="button" onclick="saveVariant(${productID}, ${Number(variant.id)})">Save</button>
+       <button class="btn danger" type="button" onclick="deleteVariant(${productID}, ${Number(variant.id)})">Delete</button>`
+    : `<button class="btn" type="button" onclick="saveVariant(${productID}, 0)">Add variant</button>`;
+  return `
+    <div class="seller-variant-row">
+      <div class="field"><input id="var-name-${key}" value="${escapeAttr(v.name || "")}" placeholder="Variant name" /></div>
+      <div class="field"><input id="var-sku-${key}" value="${escapeAttr(v.sku || "")}" placeholder="SKU" /></div>
+      <div class="field"><input id="var-price-${key}" type="number" min="0" step="0.01" value="${variant ? formatPrice(v.price) : ""}" placeholder="Price" /></div>
+      ${variant ? "" : `<div class="field"><input id="var-stock-${key}" type="number" min="0" step="0.1" placeholder="Stock" /></div>`}
+      <div class="field"><select id="var-unit-${key}">${renderUnitOptions(v.unit)}</select></div>
+      <div class="field"><input id="var-barcode-${key}" value="${escapeAttr(v.barcode || "")}" placeholder="Barcode" /></div>
+      <div class="seller-edit-actions" style="margin-top:0;">${actions}</div>
+    </div>
+  `;
+}
+
+async function saveVariant(productID, variantID) {
+  const key = variantID ? `${productID}-${variantID}` : `${productID}-new`;
+  const price = Number(document.getElementById(`var-price-${key}`)?.value);
+  // Stock is only set on a new variant; later changes are stock movements.
+  const stock = variantID ? 0 : Number(document.getElementById(`var-stock-${key}`)?.value);
+  if (!Number.isFinite(price) || !Number.isFinite(stock)) {
+    alert("Enter variant price and stock");
+    return;
+  }
+  const body = {
+    name: document.getElementById(`var-name-${key}`)?.value.trim() || "",
+    sku: document.getElementById(`var-sku-${key}`)?.value.trim() || "",
+    price,
+    unit: formatUnit(document.getElementById(`var-unit-${key}`)?.value),
+    barcode: document.getElementById(`var-barcode-${key}`)?.value.trim() || ""
+  };
+  if (!variantID) {
+    body.stock = stock;
+  }
+
+  try {
+    const res = await fetch(variantID ? `/products/${productID}/variants/${variantID}` : `/products/${productID}/variants`, {
+      method: variantID ? "PUT" : "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(body)
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      alert(data.error || "Failed to save variant");
+      return;
+    }
+
+    await loadMyProducts();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to save variant");
+  }
+}
+
+async function deleteVariant(productID, variantID) {
+  if (!confirm("Delete this variant?")) {
+    return;
+  }
+
+  try {
+    const res = await fetch(`/products/${productID}/variants/${variantID}`, {
+      method: "DELETE"
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      alert(data.error || "Failed to delete variant");
+      return;
+    }
+
+    await loadMyProducts();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to delete variant");
+  }
+}
+
+// importProducts uploads the chosen CSV file; a dry run only checks it.
+async function importProducts(dryRun) {
+  const out = document.getElementById("importOut");
+  const file = document.getElementById("importFile")?.files?.[0];
+  if (!out) return;
+  if (!file) {
+    out.innerHTML = `<div class="hint danger">Select a CSV file.</div>`;
+    return;
+  }
+
+  const form = new FormData();
+  form.append("file", file);
+  try {
+    const res = await fetch(`/seller/products/import${dryRun ? "?dry_run=true" : ""}`, {
+      method: "POST",
+      body: form
+    });
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok && !Array.isArray(data.errors)) {
+      out.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to import products")}</div>`;
+      return;
+    }
+    const errors = (data.errors || []).map(e => `
+      <div class="hint danger">Line ${Number(e.line) || "-"}${e.column ? ` · ${escapeHtml(e.column)}` : ""}: ${escapeHtml(e.message)}</div>
+    `).join("");
+    let summary = `${data.created || 0} to create, ${data.updated || 0} to update`;
+    if (errors) {
+      summary = `Nothing imported: fix the rows below. (${summary})`;
+    } else if (data.applied) {
+      summary = `Imported: ${data.created || 0} created, ${data.updated || 0} updated.`;
+    }
+    out.innerHTML = `<div class="hint" style="margin-top:8px;">${summary}</div>${errors}`;
+    if (data.applied) {
+      await loadMyProducts();
+    }
+  } catch (err) {
+    console.error(err);
+    out.innerHTML = `<div class="hint danger">Failed to import products</div>`;
+  }
+}
+
+// loadAlerts shows the open low-stock and out-of-stock alerts of the
+// seller's products.
+async function loadAlerts() {
+  const box = document.getElementById("alerts");
+  const unread = document.getElementById("alertsUnread");
+  if (!box) return;
+  try {
+    const res = await fetch("/seller/alerts?limit=50");
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      box.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to load alerts")}</div>`;
+      return;
+    }
+    if (unread) unread.textContent = data.unread ? `(${data.unread} unread)` : "";
+    const rows = (data.items || []).map(a => `
+      <div class="seller-alert ${a.read_at ? "" : "unread"}">
+        <span class="product-stock ${a.kind === "out_of_stock" ? "danger" : ""}">${a.kind === "out_of_stock" ? "Out of stock" : "Low stock"}</span>
+        <span>${escapeHtml(a.product_name)}${a.variant_name ? ` · ${escapeHtml(a.variant_name)}` : ""} · ${escapeHtml(a.sku)}</span>
+        <span class="hint">now ${a.current_stock} ${formatUnit(a.unit)}${a.kind === "low_stock" ? `, threshold ${a.threshold}` : ""} · since ${new Date(a.created_at).toLocaleString()}</span>
+        ${a.read_at ? "" : `<button class="btn" type="button" onclick="markAlertRead(${Number(a.id)})">Mark read</button>`}
+      </div>
+    `).join("");
+    box.innerHTML = rows || `<div class="hint" style="margin-top:8px;">No open alerts.</div>`;
+  } catch (err) {
+    console.error(err);
+    box.innerHTML = `<div class="hint danger">Failed to load alerts</div>`;
+  }
+}
+
+async function markAlertRead(id) {
+  try {
+    const res = await fetch(`/seller/alerts/${id}/read`, { method: "POST" });
+    if (!res.ok) {
+      const data = await res.json().catch(() => ({}));
+      alert(data.error || "Failed to mark alert read");
+      return;
+    }
+    await loadAlerts();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to mark alert read");
+  }
+}
+
+async function markAllAlertsRead() {
+  try {
+    const res = await fetch("/seller/alerts/read", { method: "POST" });
+    if (!res.ok) {
+      const data = await res.json().catch(() => ({}));
+      alert(data.error || "Failed to mark alerts read");
+      return;
+    }
+    await loadAlerts();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to mark alerts read");
+  }
+}
+
 async function saveProductRow(id) {
   const userId = localStorage.getItem("userId");
   if (!userId) {
@@ -213,10 +592,15 @@ async function saveProductRow(id) {
   const category = capitalizeFirst(document.getElementById(`edit-cat-${id}`)?.value);
   const unit = formatUnit(document.getElementById(`edit-unit-${id}`)?.value);
   const price = Number(document.getElementById(`edit-price-${id}`)?.value);
-  const stock = Number(document.getElementById(`edit-stock-${id}`)?.value);
+  const sku = document.getElementById(`edit-sku-${id}`)?.value.trim() || "";
+  const reorderThreshold = Number(document.getElementById(`edit-reorder-${id}`)?.value || 0);
+  const hideDays = Number(document.getElementById(`edit-hide-${id}`)?.value || 0);
+  const discountDays = Number(document.getElementById(`edit-discdays-${id}`)?.value || 0);
+  const discountPercent = Number(document.getElementById(`edit-discpct-${id}`)?.value || 0);
   const imageFile = document.getElementById(`edit-img-${id}`)?.files?.[0];
 
-  if (!name || !description || !category || !Number.isFinite(price) || !Number.isFinite(stock)) {
+  if (!name || !description || !category || !Number.isFinite(price) || !Number.isFinite(reorderThreshold) ||
+    !Number.isInteger(hideDays) || !Number.isInteger(discountDays) || !Number.isInteger(discountPercent)) {
     alert("Invalid input");
     return;
   }
@@ -228,7 +612,11 @@ async function saveProductRow(id) {
   form.append("category", category);
   form.append("unit", unit);
   form.append("price", String(price));
-  form.append("stock", String(stock));
+  form.append("sku", sku);
+  form.append("reorder_threshold", String(reorderThreshold));
+  form.append("expiry_hide_days", String(hideDays));
+  form.append("expiry_discount_days", String(discountDays));
+  form.append("expiry_discount_percent", String(discountPercent));
   if (imageFile) {
     form.append("image", imageFile);
   }
@@ -236,7 +624,6 @@ async function saveProductRow(id) {
   try {
     const res = await fetch("/products", {
       method: "PUT",
-      headers: { "X-User-Id": String(userId) },
       body: form
     });
     const data = await res.json().catch(() => ({}));
@@ -259,25 +646,87 @@ async function deleteProduct(id) {
     return;
   }
 
-  if (!confirm("Delete this product?")) {
+  if (!confirm("Archive this product? It leaves the catalog and can be restored later.")) {
     return;
   }
 
   try {
     const res = await fetch(`/products?id=${id}`, {
-      method: "DELETE",
-      headers: { "X-User-Id": String(userId) }
+      method: "DELETE"
     });
     const data = await res.json().catch(() => ({}));
     if (!res.ok) {
-      alert(data.error || "Failed to delete product");
+      alert(data.error || "Failed to archive product");
       return;
     }
 
     await loadMyProducts();
+    await loadArchived();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to archive product");
+  }
+}
+
+// loadArchived lists the archived products with a restore button, and for
+// administrators a purge button.
+async function loadArchived() {
+  const box = document.getElementById("archived");
+  if (!box) return;
+  const canPurge = hasPermission("product:write:any");
+  try {
+    const res = await fetch(`/products?archived=1&limit=100${canPurge ? "" : "&mine=1"}`);
+    const data = await res.json().catch(() => ({}));
+    if (!res.ok) {
+      box.innerHTML = `<div class="hint danger">${escapeHtml(data.error || "Failed to load archived products")}</div>`;
+      return;
+    }
+    const rows = (data.items || []).map(p => `
+      <div class="seller-alert">
+        <span>${escapeHtml(p.name || "Unnamed")} · ID ${Number(p.id)}</span>
+        <span class="hint">archived ${p.archived_at ? new Date(p.archived_at).toLocaleString() : "-"}</span>
+        <button class="btn" type="button" onclick="restoreProduct(${Number(p.id)})">Restore</button>
+        ${canPurge ? `<button class="btn danger" type="button" onclick="purgeProduct(${Number(p.id)})">Purge</button>` : ""}
+      </div>
+    `).join("");
+    box.innerHTML = rows || `<div class="hint" style="margin-top:8px;">No archived products.</div>`;
   } catch (err) {
     console.error(err);
-    alert("Failed to delete product");
+    box.innerHTML = `<div class="hint danger">Failed to load archived products</div>`;
+  }
+}
+
+async function restoreProduct(id) {
+  try {
+    const res = await fetch(`/products/${id}/restore`, { method: "POST" });
+    if (!res.ok) {
+      const data = await res.json().catch(() => ({}));
+      alert(data.error || "Failed to restore product");
+      return;
+    }
+    await loadMyProducts();
+    await loadArchived();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to restore product");
+  }
+}
+
+async function purgeProduct(id) {
+  if (!confirm("Delete this product permanently? This cannot be undone.")) {
+    return;
+  }
+  try {
+    const res = await fetch(`/admin/products/${id}`, { method: "DELETE" });
+    if (!res.ok) {
+      const data = await res.json().catch(() => ({}));
+      alert(data.error || "Failed to purge product");
+      return;
+    }
+    await loadArchived();
+  } catch (err) {
+    console.error(err);
+    alert("Failed to purge product");
   }
 }
 
@@ -324,10 +773,12 @@ function updateAuthButtons() {
 }
 
 function logout() {
+  fetch("/api/logout", { method: "POST", keepalive: true }).catch(() => {});
   localStorage.removeItem("userToken");
   localStorage.removeItem("userEmail");
   localStorage.removeItem("userName");
   localStorage.removeItem("userRole");
+  localStorage.removeItem("userPermissions");
   localStorage.removeItem("userDate");
   localStorage.removeItem("userId");
   updateAuthButtons();
@@ -335,11 +786,10 @@ function logout() {
 }
 
 function ensureSellerAccess() {
-  const role = localStorage.getItem("userRole") || "buyer";
   const notice = document.getElementById("sellerOnlyNotice");
   const panel = document.getElementById("sellerPanel");
 
-  if (!canManageProductsRole(role)) {
+  if (!hasPermission("product:write:own")) {
     if (notice) notice.style.display = "block";
     if (panel) panel.style.display = "none";
     return false;
@@ -350,6 +800,31 @@ function ensureSellerAccess() {
   return true;
 }
 
+// loadCategoryOptions fills the category suggestions with every category of
+// the tree; products can only be filed under an existing category.
+async function loadCategoryOptions() {
+  const list = document.getElementById("categoryOptions");
+  if (!list) return;
+  try {
+    const res = await fetch("/categories");
+    if (!res.ok) return;
+    const tree = await res.json();
+    const names = [];
+    const walk = (nodes, prefix) => {
+      (nodes || []).forEach((node) => {
+        names.push({ name: node.name, path: prefix + node.name });
+        walk(node.children, `${prefix}${node.name} / `);
+      });
+    };
+    walk(tree, "");
+    list.innerHTML = names
+      .map((c) => `<option value="${escapeAttr(c.name)}">${escapeAttr(c.path)}</option>`)
+      .join("");
+  } catch (err) {
+    console.error(err);
+  }
+}
+
 function initSellerProductsPage() {
   updateAuthButtons();
 
@@ -362,7 +837,11 @@ function initSellerProductsPage() {
     searchInput.addEventListener("input", render);
   }
 
+  loadCategoryOptions();
   loadMyProducts();
+  loadAlerts();
+  loadExpiring();
+  loadArchived();
 }
 
 if (document.readyState === "loading") {
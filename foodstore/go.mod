@@ -2,4 +2,7 @@ module foodstore
 
 go 1.22
 
-require github.com/lib/pq v1.11.1
+require (
+	github.com/lib/pq v1.11.1
+	golang.org/x/crypto v0.31.0
+)
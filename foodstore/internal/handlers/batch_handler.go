@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ListBatches serves GET /products/{id}/batches, the lots of a product that
+// hold stock, for whoever may edit it.
+func (ph *ProductHandler) ListBatches(w http.ResponseWriter, r *http.Request) {
+	productID, _, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	batches, err := ph.service.ListBatches(productID)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, batches)
+}
+
+// ExpiringStock serves GET /seller/expiring?days=, the caller's stock whose
+// best-before date is at most days away. Users who may edit any product see
+// every seller's stock, or one seller's with seller_id.
+func (ph *ProductHandler) ExpiringStock(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	values := r.URL.Query()
+	sellerID := userID
+	if ph.canWriteAnyProduct(r) {
+		sellerID = 0
+		if raw := strings.TrimSpace(values.Get("seller_id")); raw != "" {
+			id, err := strconv.Atoi(raw)
+			if err != nil || id <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "invalid seller_id")
+				return
+			}
+			sellerID = id
+		}
+	}
+	var days *int
+	if raw := strings.TrimSpace(values.Get("days")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid days")
+			return
+		}
+		days = &n
+	}
+	report, err := ph.service.ExpiringStock(sellerID, days)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"foodstore/internal/middleware"
+	"foodstore/internal/models"
+	"foodstore/internal/services"
+)
+
+// cartCookieName holds the token of an anonymous visitor's cart until they
+// log in and the cart is merged into theirs.
+const (
+	cartCookieName = "foodstore_cart"
+	cartCookieTTL  = 30 * 24 * time.Hour
+)
+
+type CartHandler struct {
+	service *services.CartService
+}
+
+func NewCartHandler(cs *services.CartService) *CartHandler {
+	return &CartHandler{service: cs}
+}
+
+func (ch *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, token := cartOwner(r)
+	cart, err := ch.service.GetCart(userID, sessionID, token)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
+	userID, _, token := cartOwner(r)
+	if err := ch.service.Clear(userID, token); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "cleared"})
+}
+
+func (ch *CartHandler) AddItem(w http.ResponseWriter, r *http.Request) {
+	var reqBody struct {
+		ProductID int             `json:"product_id"`
+		VariantID int             `json:"variant_id"`
+		Quantity  models.Quantity `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.ProductID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "product_id and quantity are required")
+		return
+	}
+
+	userID, sessionID, token := cartOwner(r)
+	cart, newToken, err := ch.service.AddItem(userID, sessionID, token, reqBody.ProductID, reqBody.VariantID, reqBody.Quantity)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	if newToken != "" {
+		setCartCookie(w, r, newToken)
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	variantID, err := strconv.Atoi(r.PathValue("variant_id"))
+	if err != nil || variantID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid variant id")
+		return
+	}
+	var reqBody struct {
+		Quantity models.Quantity `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "quantity is required")
+		return
+	}
+
+	userID, sessionID, token := cartOwner(r)
+	cart, err := ch.service.UpdateItem(userID, sessionID, token, variantID, reqBody.Quantity)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	variantID, err := strconv.Atoi(r.PathValue("variant_id"))
+	if err != nil || variantID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid variant id")
+		return
+	}
+
+	userID, sessionID, token := cartOwner(r)
+	cart, err := ch.service.RemoveItem(userID, sessionID, token, variantID)
+	if err != nil {
+		writeCartError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) Reserve(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	cart, err := ch.service.Reserve(userID, currentSessionID(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrCartEmpty):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrInsufficientStock):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	cart, err := ch.service.ReleaseReservation(userID, currentSessionID(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, cart)
+}
+
+func (ch *CartHandler) Checkout(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var reqBody struct {
+		Currency        string `json:"currency"`
+		DeliveryAddress string `json:"delivery_address"`
+		PhoneNumber     string `json:"phone_number"`
+		Comment         string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	orderID, err := ch.service.Checkout(userID, currentSessionID(r), reqBody.Currency, reqBody.DeliveryAddress, reqBody.PhoneNumber, reqBody.Comment)
+	if err != nil {
+		if errors.Is(err, services.ErrCartEmpty) || isPlaceOrderInputError(err) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"order_id": orderID})
+}
+
+func writeCartError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrVariantNotFound), errors.Is(err, services.ErrCartItemNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidQuantity), errors.Is(err, services.ErrInsufficientStock), errors.Is(err, services.ErrVariantRequired):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// cartOwner identifies the cart of the request: the logged-in user's, with
+// the session whose checkout holds apply, or the anonymous cart named by the
+// cart cookie.
+func cartOwner(r *http.Request) (int, int, string) {
+	if user := middleware.CurrentUser(r); user != nil {
+		return user.ID, currentSessionID(r), ""
+	}
+	if cookie, err := r.Cookie(cartCookieName); err == nil {
+		return 0, 0, cookie.Value
+	}
+	return 0, 0, ""
+}
+
+// currentSessionID returns the ID of the request's login session, which
+// stock holds are kept for, or zero for anonymous requests.
+func currentSessionID(r *http.Request) int {
+	if session := middleware.CurrentSession(r); session != nil {
+		return session.ID
+	}
+	return 0
+}
+
+func setCartCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(cartCookieTTL),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearCartCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     cartCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"foodstore/internal/services"
+)
+
+type CategoryHandler struct {
+	service *services.CategoryService
+}
+
+func NewCategoryHandler(cs *services.CategoryService) *CategoryHandler {
+	return &CategoryHandler{service: cs}
+}
+
+// ListCategories returns the whole category tree.
+func (ch *CategoryHandler) ListCategories(w http.ResponseWriter, r *http.Request) {
+	tree, err := ch.service.Tree()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, tree)
+}
+
+type categoryRequest struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID int    `json:"parent_id"`
+}
+
+func (ch *CategoryHandler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	var reqBody categoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	category, err := ch.service.CreateCategory(reqBody.Name, reqBody.Slug, reqBody.ParentID)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+func (ch *CategoryHandler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid category id")
+		return
+	}
+	// Fields left out of the body keep their stored values.
+	var reqBody struct {
+		Name     *string `json:"name"`
+		Slug     *string `json:"slug"`
+		ParentID *int    `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	category, err := ch.service.UpdateCategory(id, reqBody.Name, reqBody.Slug, reqBody.ParentID)
+	if err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, category)
+}
+
+// DeleteCategory serves DELETE /admin/categories/{id}?move_to=, moving the
+// category's products to move_to before deleting it.
+func (ch *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid category id")
+		return
+	}
+	moveTo := 0
+	if raw := strings.TrimSpace(r.URL.Query().Get("move_to")); raw != "" {
+		moveTo, err = strconv.Atoi(raw)
+		if err != nil || moveTo <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid move_to")
+			return
+		}
+	}
+	if err := ch.service.DeleteCategory(id, moveTo); err != nil {
+		writeCategoryError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+func writeCategoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrCategoryNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidCategory), errors.Is(err, services.ErrCategoryCycle):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrCategorySlugTaken), errors.Is(err, services.ErrCategoryInUse):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -76,11 +76,11 @@ func (ch *ContactHandler) ListMessagesForAdmin(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	adminID, err := getUserIDFromHeader(r)
+	adminID, err := currentUserID(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "missing or invalid user id"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "authentication required"})
 		return
 	}
 
@@ -103,7 +103,7 @@ func (ch *ContactHandler) ListMessagesForAdmin(w http.ResponseWriter, r *http.Re
 }
 
 func parseOptionalUserID(r *http.Request) int {
-	userID, err := getUserIDFromHeader(r)
+	userID, err := currentUserID(r)
 	if err != nil {
 		return 0
 	}
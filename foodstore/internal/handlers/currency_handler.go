@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"foodstore/internal/models"
+	"foodstore/internal/services"
+)
+
+type CurrencyHandler struct {
+	service *services.CurrencyService
+}
+
+func NewCurrencyHandler(cs *services.CurrencyService) *CurrencyHandler {
+	return &CurrencyHandler{service: cs}
+}
+
+func (ch *CurrencyHandler) ListRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := ch.service.ListRates()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"base_currency": ch.service.BaseCurrency(),
+		"rates":         rates,
+	})
+}
+
+func (ch *CurrencyHandler) SetRate(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	var reqBody struct {
+		Currency string      `json:"currency"`
+		Rate     models.Rate `json:"rate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "currency and a positive rate are required")
+		return
+	}
+
+	if err := ch.service.SetRate(userID, reqBody.Currency, reqBody.Rate); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCurrency), errors.Is(err, models.ErrInvalidRate), errors.Is(err, services.ErrBaseCurrency):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "saved"})
+}
+
+func (ch *CurrencyHandler) DeleteRate(w http.ResponseWriter, r *http.Request) {
+	deleted, err := ch.service.DeleteRate(r.PathValue("currency"))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidCurrency), errors.Is(err, services.ErrBaseCurrency):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, services.ErrCurrencyInUse):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	if !deleted {
+		writeJSONError(w, http.StatusNotFound, "exchange rate not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
@@ -4,38 +4,37 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"foodstore/internal/models"
 	"foodstore/internal/services"
 )
 
+// idempotencyKeyHeader lets clients retry POST /orders without placing the
+// order twice.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 type OrderHandler struct {
-	service *services.OrderService
+	service     *services.OrderService
+	idempotency *services.IdempotencyService
 }
 
-func NewOrderHandler(os *services.OrderService) *OrderHandler {
-	return &OrderHandler{service: os}
+func NewOrderHandler(os *services.OrderService, is *services.IdempotencyService) *OrderHandler {
+	return &OrderHandler{service: os, idempotency: is}
 }
 
 func (oh *OrderHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
-		userIDStr := r.URL.Query().Get("user_id")
-		if userIDStr == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "user_id is required"})
-			return
-		}
-		userID, err := strconv.Atoi(userIDStr)
-		if err != nil || userID <= 0 {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(map[string]string{"error": "invalid user_id"})
-			return
-		}
 		orders, err := oh.service.ListOrdersByUserID(userID)
 		if err != nil {
 			if errors.Is(err, services.ErrInvalidOrder) || errors.Is(err, services.ErrUserNotFound) {
@@ -54,13 +53,14 @@ func (oh *OrderHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	case http.MethodPost:
 		var reqBody struct {
-			UserID          int    `json:"user_id"`
+			Currency        string `json:"currency"`
 			DeliveryAddress string `json:"delivery_address"`
 			PhoneNumber     string `json:"phone_number"`
 			Comment         string `json:"comment"`
 			Items           []struct {
-				ProductID int `json:"product_id"`
-				Quantity  int `json:"quantity"`
+				ProductID int             `json:"product_id"`
+				VariantID int             `json:"variant_id"`
+				Quantity  models.Quantity `json:"quantity"`
 			} `json:"items"`
 		}
 		body, err := io.ReadAll(r.Body)
@@ -74,16 +74,59 @@ func (oh *OrderHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON format"})
 			return
 		}
+		idempotencyKey := strings.TrimSpace(r.Header.Get(idempotencyKeyHeader))
+		if idempotencyKey != "" {
+			saved, err := oh.idempotency.Begin(userID, idempotencyKey, body)
+			if err != nil {
+				switch {
+				case errors.Is(err, services.ErrInvalidIdempotencyKey):
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+				case errors.Is(err, services.ErrIdempotencyKeyReused):
+					writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+				case errors.Is(err, services.ErrIdempotencyInProgress):
+					writeJSONError(w, http.StatusConflict, err.Error())
+				default:
+					writeJSONError(w, http.StatusInternalServerError, err.Error())
+				}
+				return
+			}
+			if saved != nil {
+				statusCode, response := saved.StatusCode, saved.Response
+				if len(response) == 0 {
+					// The order was placed but its response was never stored.
+					statusCode = http.StatusOK
+					response, err = placedOrderResponse(saved.OrderID)
+					if err != nil {
+						writeJSONError(w, http.StatusInternalServerError, err.Error())
+						return
+					}
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(statusCode)
+				w.Write(response)
+				return
+			}
+		}
+
 		items := make([]models.OrderItem, len(reqBody.Items))
 		for i, item := range reqBody.Items {
 			items[i] = models.OrderItem{
 				ProductID: item.ProductID,
+				VariantID: item.VariantID,
 				Quantity:  item.Quantity,
 			}
 		}
-		orderID, err := oh.service.PlaceOrder(reqBody.UserID, items, reqBody.DeliveryAddress, reqBody.PhoneNumber, reqBody.Comment)
+		orderID, err := oh.service.PlaceOrder(userID, currentSessionID(r), items, reqBody.Currency, reqBody.DeliveryAddress, reqBody.PhoneNumber, reqBody.Comment, idempotencyKey)
 		if err != nil {
-			if errors.Is(err, services.ErrInvalidOrder) || errors.Is(err, services.ErrUserNotFound) || errors.Is(err, services.ErrProductNotFound) || errors.Is(err, services.ErrInsufficientStock) {
+			if idempotencyKey != "" {
+				oh.idempotency.Release(userID, idempotencyKey)
+			}
+			if errors.Is(err, services.ErrIdempotencyInProgress) {
+				writeJSONError(w, http.StatusConflict, err.Error())
+				return
+			}
+			if isPlaceOrderInputError(err) {
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusBadRequest)
 				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
@@ -94,24 +137,58 @@ func (oh *OrderHandler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 			return
 		}
+		response, err := placedOrderResponse(orderID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if idempotencyKey != "" {
+			if err := oh.idempotency.Complete(userID, idempotencyKey, http.StatusOK, response); err != nil {
+				log.Printf("storing idempotent response for order %d failed: %v", orderID, err)
+			}
+		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]interface{}{"order_id": orderID})
+		w.Write(append(response, '\n'))
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
+// placedOrderResponse is the body POST /orders answers a placed order with.
+func placedOrderResponse(orderID int) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"order_id": orderID})
+}
+
+func isPlaceOrderInputError(err error) bool {
+	for _, target := range []error{
+		services.ErrInvalidOrder,
+		services.ErrUserNotFound,
+		services.ErrProductNotFound,
+		services.ErrVariantNotFound,
+		services.ErrVariantRequired,
+		services.ErrInsufficientStock,
+		services.ErrInvalidQuantity,
+		services.ErrUnknownCurrency,
+		models.ErrInvalidCurrency,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
 func (oh *OrderHandler) SellerOrders(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	sellerID, err := getUserIDFromHeader(r)
+	sellerID, err := currentUserID(r)
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "missing or invalid user id"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "authentication required"})
 		return
 	}
 
@@ -132,3 +209,166 @@ func (oh *OrderHandler) SellerOrders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orders)
 }
+
+func (oh *OrderHandler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	var reqBody struct {
+		Status   string `json:"status"`
+		Note     string `json:"note"`
+		SellerID int    `json:"seller_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+		return
+	}
+
+	if err := oh.service.UpdateOrderStatus(userID, orderID, reqBody.SellerID, reqBody.Status, reqBody.Note); err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrOrderForbidden):
+			writeJSONError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, services.ErrStatusTransition):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrInvalidOrder), errors.Is(err, services.ErrInvalidStatus), errors.Is(err, services.ErrUserNotFound):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"order_id": orderID, "status": strings.ToLower(strings.TrimSpace(reqBody.Status))})
+}
+
+func (oh *OrderHandler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	var reqBody struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+	}
+
+	if err := oh.service.CancelOrder(userID, orderID, reqBody.Reason); err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrNotCancellable):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrInvalidOrder):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"order_id": orderID, "status": "cancelled"})
+}
+
+func (oh *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	order, err := oh.service.GetOrder(userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrInvalidOrder), errors.Is(err, services.ErrUserNotFound):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (oh *OrderHandler) OrderHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	orderID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || orderID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid order id")
+		return
+	}
+
+	history, err := oh.service.GetOrderHistory(userID, orderID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOrderNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrInvalidOrder), errors.Is(err, services.ErrUserNotFound):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (oh *OrderHandler) AdminUserOrders(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	if err != nil || userID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid user_id")
+		return
+	}
+
+	orders, err := oh.service.ListOrdersByUserID(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrInvalidOrder):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, orders)
+}
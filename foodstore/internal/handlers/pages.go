@@ -3,6 +3,8 @@ package handlers
 import (
 	"html/template"
 	"net/http"
+
+	"foodstore/internal/models"
 )
 
 func HomePage(w http.ResponseWriter, r *http.Request) {
@@ -25,7 +27,15 @@ func SellerOrdersPage(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "frontend/pages/seller_orders.html")
 }
 
-func OrdersPage(w http.ResponseWriter, r *http.Request) {
+// OrdersPage renders the orders page; the sample rows are priced in the
+// store's base currency.
+func OrdersPage(baseCurrency string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		renderOrdersPage(w, baseCurrency)
+	}
+}
+
+func renderOrdersPage(w http.ResponseWriter, baseCurrency string) {
 	tmpl, err := template.ParseFiles("frontend/pages/orders.html")
 	if err != nil {
 		http.Error(w, "template error", http.StatusInternalServerError)
@@ -50,8 +60,8 @@ func OrdersPage(w http.ResponseWriter, r *http.Request) {
 			Total   string
 			Created string
 		}{
-			{ID: 101, UserID: 7, Items: "2x Apples, 1x Milk", Total: "5800.00 ₸", Created: "2026-02-10 22:30"},
-			{ID: 102, UserID: 7, Items: "1x Bread, 3x Eggs", Total: "4200.00 ₸", Created: "2026-02-09 18:05"},
+			{ID: 101, UserID: 7, Items: "2x Apples, 1x Milk", Total: models.Money(580000).String() + " " + baseCurrency, Created: "2026-02-10 22:30"},
+			{ID: 102, UserID: 7, Items: "1x Bread, 3x Eggs", Total: models.Money(420000).String() + " " + baseCurrency, Created: "2026-02-09 18:05"},
 		},
 	}
 
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"foodstore/internal/services"
+)
+
+// RestoreProduct serves POST /products/{id}/restore, putting an archived
+// product back on sale.
+func (ph *ProductHandler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	productID, userID, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	var (
+		restored bool
+		err      error
+	)
+	if ph.canWriteAnyProduct(r) {
+		restored, err = ph.service.RestoreProductAsAdmin(productID)
+	} else {
+		restored, err = ph.service.RestoreProduct(productID, userID)
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !restored {
+		writeJSONError(w, http.StatusNotFound, "product not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "restored"})
+}
+
+// PurgeProduct serves DELETE /admin/products/{id}, deleting an archived
+// product that no order refers to for good, image included.
+func (ph *ProductHandler) PurgeProduct(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	existing, err := ph.service.GetProductByID(productID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "product not found")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err := ph.service.PurgeProduct(productID); err != nil {
+		switch {
+		case errors.Is(err, services.ErrProductNotFound):
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case errors.Is(err, services.ErrProductNotArchived), errors.Is(err, services.ErrProductReferenced):
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+	deleteLocalProductImage(existing.ImageURL, ph.uploadDir)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "purged"})
+}
@@ -13,57 +13,74 @@ import (
 	"strings"
 	"time"
 
+	"foodstore/internal/middleware"
 	"foodstore/internal/models"
+	"foodstore/internal/policy"
 	"foodstore/internal/services"
 )
 
 type ProductHandler struct {
-	service     *services.ProductService
-	userService *services.UserService
-	uploadDir   string
+	service   *services.ProductService
+	policy    *policy.Policy
+	uploadDir string
 }
 
 const (
 	maxUploadFileBytes = int64(8 << 20) // 8MB
 	maxFormMemoryBytes = int64(16 << 20)
 	defaultUploadDir   = "frontend/uploads"
+	// errStockEdit answers edits that try to set stock, which would undo
+	// the sales and cancellations recorded since the form was loaded.
+	errStockEdit = "stock cannot be edited; record a receipt, spoilage or adjustment with POST /products/{id}/stock-movements"
 )
 
-func NewProductHandler(ps *services.ProductService, us *services.UserService, uploadDir string) *ProductHandler {
+func NewProductHandler(ps *services.ProductService, pol *policy.Policy, uploadDir string) *ProductHandler {
 	dir := strings.TrimSpace(uploadDir)
 	if dir == "" {
 		dir = defaultUploadDir
 	}
-	return &ProductHandler{service: ps, userService: us, uploadDir: dir}
+	return &ProductHandler{service: ps, policy: pol, uploadDir: dir}
 }
 
 func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		var (
-			products []models.Product
-			err      error
-		)
-
+		query, err := parseProductQuery(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if r.URL.Query().Get("mine") == "1" {
-			userID, userErr := getUserIDFromHeader(r)
+			userID, userErr := currentUserID(r)
 			if userErr != nil {
-				writeJSONError(w, http.StatusUnauthorized, "missing or invalid user id")
+				writeJSONError(w, http.StatusUnauthorized, "authentication required")
 				return
 			}
-			products, err = ph.service.ListProductsBySellerID(userID)
-		} else {
-			products, err = ph.service.ListProducts()
+			query.SellerID = userID
 		}
+		// Archived products are listed to their seller (with mine=1) and to
+		// users who may edit any product.
+		if r.URL.Query().Get("archived") == "1" {
+			if r.URL.Query().Get("mine") != "1" && !ph.canWriteAnyProduct(r) {
+				writeJSONError(w, http.StatusForbidden, "archived products are listed only with mine=1")
+				return
+			}
+			query.Archived = true
+		}
+		page, err := ph.service.ListProducts(query, r.URL.Query().Get("currency"))
 		if err != nil {
+			if isCurrencyInputError(err) || errors.Is(err, services.ErrInvalidProductQuery) {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
 			writeJSONError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		writeJSON(w, http.StatusOK, products)
+		writeJSON(w, http.StatusOK, page)
 	case http.MethodPost:
-		userID, err := getUserIDFromHeader(r)
+		userID, err := currentUserID(r)
 		if err != nil {
-			writeJSONError(w, http.StatusUnauthorized, "missing or invalid user id")
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
 
@@ -73,8 +90,12 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if reqBody.Name == "" || reqBody.Description == "" || reqBody.Category == "" {
-			writeJSONError(w, http.StatusBadRequest, "name, description, category are required")
+		if reqBody.Name == "" || reqBody.Description == "" || (reqBody.CategoryID <= 0 && reqBody.Category == "") {
+			writeJSONError(w, http.StatusBadRequest, "name, description, category_id or category are required")
+			return
+		}
+		if !reqBody.HasStock {
+			writeJSONError(w, http.StatusBadRequest, "invalid stock")
 			return
 		}
 		if reqBody.Price < 0 || reqBody.Stock < 0 {
@@ -83,16 +104,18 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 		}
 
 		id, err := ph.service.CreateProduct(models.Product{
-			Name:        reqBody.Name,
-			Description: reqBody.Description,
-			ImageURL:    reqBody.ImageURL,
-			Price:       reqBody.Price,
-			Stock:       reqBody.Stock,
-			Category:    reqBody.Category,
-			Unit:        reqBody.Unit,
+			Name:             reqBody.Name,
+			Description:      reqBody.Description,
+			ImageURL:         reqBody.ImageURL,
+			Currency:         reqBody.Currency,
+			CategoryID:       reqBody.CategoryID,
+			Category:         reqBody.Category,
+			Variants:         []models.ProductVariant{reqBody.defaultVariant()},
+			ReorderThreshold: reqBody.ReorderThreshold,
+			Expiry:           reqBody.expiryPolicy(models.ExpiryPolicy{}),
 		}, userID)
 		if err != nil {
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			writeProductWriteError(w, err)
 			return
 		}
 
@@ -101,16 +124,12 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 			"image_url": reqBody.ImageURL,
 		})
 	case http.MethodPut:
-		userID, err := getUserIDFromHeader(r)
+		userID, err := currentUserID(r)
 		if err != nil {
-			writeJSONError(w, http.StatusUnauthorized, "missing or invalid user id")
-			return
-		}
-		isAdmin, err := ph.isAdministrator(userID)
-		if err != nil {
-			writeJSONError(w, http.StatusUnauthorized, "user not found")
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
+		canWriteAny := ph.canWriteAnyProduct(r)
 
 		reqBody, err := parseProductMultipart(r, false, ph.uploadDir)
 		if err != nil {
@@ -121,12 +140,16 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusBadRequest, "id is required")
 			return
 		}
-		if reqBody.Name == "" || reqBody.Description == "" || reqBody.Category == "" {
-			writeJSONError(w, http.StatusBadRequest, "name, description, category are required")
+		if reqBody.Name == "" || reqBody.Description == "" || (reqBody.CategoryID <= 0 && reqBody.Category == "") {
+			writeJSONError(w, http.StatusBadRequest, "name, description, category_id or category are required")
 			return
 		}
-		if reqBody.Price < 0 || reqBody.Stock < 0 {
-			writeJSONError(w, http.StatusBadRequest, "price and stock must be >= 0")
+		if reqBody.HasStock {
+			writeJSONError(w, http.StatusBadRequest, errStockEdit)
+			return
+		}
+		if reqBody.Price < 0 {
+			writeJSONError(w, http.StatusBadRequest, "price must be >= 0")
 			return
 		}
 
@@ -139,7 +162,7 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if !isAdmin && existing.SellerID != userID {
+		if !canWriteAny && existing.SellerID != userID {
 			writeJSONError(w, http.StatusForbidden, "you can edit only your own products")
 			return
 		}
@@ -148,25 +171,31 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 		if reqBody.HasImage {
 			imageURL = reqBody.ImageURL
 		}
+		threshold := existing.ReorderThreshold
+		if reqBody.HasReorderThreshold {
+			threshold = reqBody.ReorderThreshold
+		}
 
 		productToUpdate := models.Product{
-			ID:          reqBody.ID,
-			Name:        reqBody.Name,
-			Description: reqBody.Description,
-			ImageURL:    imageURL,
-			Price:       reqBody.Price,
-			Stock:       reqBody.Stock,
-			Category:    reqBody.Category,
-			Unit:        reqBody.Unit,
+			ID:               reqBody.ID,
+			Name:             reqBody.Name,
+			Description:      reqBody.Description,
+			ImageURL:         imageURL,
+			Currency:         reqBody.Currency,
+			CategoryID:       reqBody.CategoryID,
+			Category:         reqBody.Category,
+			Variants:         []models.ProductVariant{reqBody.defaultVariant()},
+			ReorderThreshold: threshold,
+			Expiry:           reqBody.expiryPolicy(existing.Expiry),
 		}
 		var updated bool
-		if isAdmin {
+		if canWriteAny {
 			updated, err = ph.service.UpdateProductAsAdmin(productToUpdate)
 		} else {
 			updated, err = ph.service.UpdateProduct(productToUpdate, userID)
 		}
 		if err != nil {
-			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			writeProductWriteError(w, err)
 			return
 		}
 		if !updated {
@@ -180,16 +209,12 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 
 		writeJSON(w, http.StatusOK, map[string]string{"status": "updated"})
 	case http.MethodDelete:
-		userID, err := getUserIDFromHeader(r)
+		userID, err := currentUserID(r)
 		if err != nil {
-			writeJSONError(w, http.StatusUnauthorized, "missing or invalid user id")
-			return
-		}
-		isAdmin, err := ph.isAdministrator(userID)
-		if err != nil {
-			writeJSONError(w, http.StatusUnauthorized, "user not found")
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
 			return
 		}
+		canWriteAny := ph.canWriteAnyProduct(r)
 
 		idStr := r.URL.Query().Get("id")
 		id, err := strconv.Atoi(idStr)
@@ -207,51 +232,182 @@ func (ph *ProductHandler) ListProducts(w http.ResponseWriter, r *http.Request) {
 			writeJSONError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if !isAdmin && existing.SellerID != userID {
+		if !canWriteAny && existing.SellerID != userID {
 			writeJSONError(w, http.StatusForbidden, "you can delete only your own products")
 			return
 		}
 
-		var deleted bool
-		if isAdmin {
-			deleted, err = ph.service.DeleteProductAsAdmin(id)
+		// Deleting archives the product, which keeps its image for a restore.
+		var archived bool
+		if canWriteAny {
+			archived, err = ph.service.ArchiveProductAsAdmin(id)
 		} else {
-			deleted, err = ph.service.DeleteProduct(id, userID)
+			archived, err = ph.service.ArchiveProduct(id, userID)
 		}
 		if err != nil {
 			writeJSONError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
-		if !deleted {
+		if !archived {
 			writeJSONError(w, http.StatusNotFound, "product not found")
 			return
 		}
 
-		deleteLocalProductImage(existing.ImageURL, ph.uploadDir)
-		writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+		writeJSON(w, http.StatusOK, map[string]string{"status": "archived"})
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func (ph *ProductHandler) isAdministrator(userID int) (bool, error) {
-	user, err := ph.userService.GetUserByID(userID)
+func isCurrencyInputError(err error) bool {
+	return errors.Is(err, services.ErrUnknownCurrency) || errors.Is(err, models.ErrInvalidCurrency)
+}
+
+// writeProductWriteError maps errors from creating or updating a product,
+// its variants or its stock.
+func writeProductWriteError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrProductNotFound), errors.Is(err, services.ErrVariantNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrBatchNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case isCurrencyInputError(err), errors.Is(err, services.ErrCategoryNotFound), errors.Is(err, services.ErrInvalidVariant),
+		errors.Is(err, services.ErrVariantRequired), errors.Is(err, services.ErrInvalidStockMovement), errors.Is(err, services.ErrInvalidMovementQuery),
+		errors.Is(err, services.ErrInvalidBatch), errors.Is(err, services.ErrInvalidExpiryPolicy), errors.Is(err, services.ErrInvalidExpiryQuery):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrSKUTaken), errors.Is(err, services.ErrBarcodeTaken), errors.Is(err, services.ErrLastVariant),
+		errors.Is(err, services.ErrInsufficientStock), errors.Is(err, services.ErrLotMismatch):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// SearchProducts serves GET /products/search?q=, taking the same filters,
+// sorts and paging as GET /products.
+func (ph *ProductHandler) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	query, err := parseProductQuery(r)
 	if err != nil {
-		return false, err
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	return user.Role == "administrator", nil
+	page, err := ph.service.SearchProducts(r.URL.Query().Get("q"), query, r.URL.Query().Get("currency"))
+	if err != nil {
+		if isCurrencyInputError(err) || errors.Is(err, services.ErrInvalidProductQuery) || errors.Is(err, services.ErrEmptySearch) {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// parseProductQuery reads the listing filters of GET /products.
+func parseProductQuery(r *http.Request) (models.ProductQuery, error) {
+	values := r.URL.Query()
+	q := models.ProductQuery{
+		Category: strings.TrimSpace(values.Get("category")),
+		Sort:     strings.ToLower(strings.TrimSpace(values.Get("sort"))),
+	}
+	for _, param := range []struct {
+		name string
+		dst  *int
+	}{
+		{"seller_id", &q.SellerID},
+		{"category_id", &q.CategoryID},
+		{"limit", &q.Limit},
+		{"offset", &q.Offset},
+	} {
+		raw := strings.TrimSpace(values.Get(param.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s", param.name)
+		}
+		*param.dst = n
+	}
+	for _, param := range []struct {
+		name string
+		dst  **models.Money
+	}{
+		{"min_price", &q.MinPrice},
+		{"max_price", &q.MaxPrice},
+	} {
+		raw := strings.TrimSpace(values.Get(param.name))
+		if raw == "" {
+			continue
+		}
+		price, err := models.ParseMoney(raw)
+		if err != nil || price < 0 {
+			return q, fmt.Errorf("invalid %s", param.name)
+		}
+		*param.dst = &price
+	}
+	switch strings.ToLower(strings.TrimSpace(values.Get("in_stock"))) {
+	case "", "0", "false":
+	case "1", "true":
+		q.InStock = true
+	default:
+		return q, errors.New("invalid in_stock")
+	}
+	return q, nil
+}
+
+func (ph *ProductHandler) canWriteAnyProduct(r *http.Request) bool {
+	user := middleware.CurrentUser(r)
+	return user != nil && ph.policy.Can(user.Role, policy.ProductWriteAny)
 }
 
 type productMultipartRequest struct {
-	ID          int
-	Name        string
-	Description string
-	ImageURL    string
-	Price       float64
-	Stock       int
-	Category    string
-	Unit        string
-	HasImage    bool
+	ID                  int
+	Name                string
+	Description         string
+	ImageURL            string
+	Price               models.Money
+	Currency            string
+	Stock               models.Quantity
+	HasStock            bool
+	CategoryID          int
+	Category            string
+	Unit                string
+	SKU                 string
+	Barcode             string
+	HasImage            bool
+	ReorderThreshold    models.Quantity
+	HasReorderThreshold bool
+	// The expiry policy fields are nil when left out of the form.
+	ExpiryHideDays        *int
+	ExpiryDiscountDays    *int
+	ExpiryDiscountPercent *int
+}
+
+// defaultVariant is the variant the price, stock, unit, sku and barcode
+// fields of the product form apply to.
+func (req productMultipartRequest) defaultVariant() models.ProductVariant {
+	return models.ProductVariant{
+		SKU:     req.SKU,
+		Price:   req.Price,
+		Stock:   req.Stock,
+		Unit:    req.Unit,
+		Barcode: req.Barcode,
+	}
+}
+
+// expiryPolicy is base with the expiry policy fields of the form applied.
+func (req productMultipartRequest) expiryPolicy(base models.ExpiryPolicy) models.ExpiryPolicy {
+	if req.ExpiryHideDays != nil {
+		base.HideDays = *req.ExpiryHideDays
+	}
+	if req.ExpiryDiscountDays != nil {
+		base.DiscountDays = *req.ExpiryDiscountDays
+	}
+	if req.ExpiryDiscountPercent != nil {
+		base.DiscountPercent = *req.ExpiryDiscountPercent
+	}
+	return base
 }
 
 func parseProductMultipart(r *http.Request, imageRequired bool, uploadDir string) (productMultipartRequest, error) {
@@ -264,18 +420,25 @@ func parseProductMultipart(r *http.Request, imageRequired bool, uploadDir string
 		return productMultipartRequest{}, errors.New("invalid multipart form")
 	}
 
-	price, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("price")), 64)
+	price, err := models.ParseMoney(r.FormValue("price"))
 	if err != nil {
 		return productMultipartRequest{}, errors.New("invalid price")
 	}
-	stock, err := strconv.Atoi(strings.TrimSpace(r.FormValue("stock")))
-	if err != nil {
-		return productMultipartRequest{}, errors.New("invalid stock")
+	var stock models.Quantity
+	rawStock := strings.TrimSpace(r.FormValue("stock"))
+	if rawStock != "" {
+		stock, err = models.ParseQuantity(rawStock)
+		if err != nil {
+			return productMultipartRequest{}, errors.New("invalid stock")
+		}
 	}
 	unit, err := normalizeProductUnit(r.FormValue("unit"))
 	if err != nil {
 		return productMultipartRequest{}, err
 	}
+	if rule := models.RuleForUnit(unit); stock >= 0 && !rule.OnStep(stock) {
+		return productMultipartRequest{}, fmt.Errorf("stock must be a multiple of %s for unit %s", rule.Step, unit)
+	}
 
 	id := 0
 	if idStr := strings.TrimSpace(r.FormValue("id")); idStr != "" {
@@ -285,6 +448,34 @@ func parseProductMultipart(r *http.Request, imageRequired bool, uploadDir string
 		}
 		id = parsedID
 	}
+	var threshold models.Quantity
+	rawThreshold := strings.TrimSpace(r.FormValue("reorder_threshold"))
+	if rawThreshold != "" {
+		threshold, err = models.ParseQuantity(rawThreshold)
+		if err != nil || threshold < 0 {
+			return productMultipartRequest{}, errors.New("invalid reorder_threshold")
+		}
+	}
+	expiry := make([]*int, 3)
+	for i, name := range []string{"expiry_hide_days", "expiry_discount_days", "expiry_discount_percent"} {
+		raw := strings.TrimSpace(r.FormValue(name))
+		if raw == "" {
+			continue
+		}
+		n, parseErr := strconv.Atoi(raw)
+		if parseErr != nil {
+			return productMultipartRequest{}, errors.New("invalid " + name)
+		}
+		expiry[i] = &n
+	}
+	categoryID := 0
+	if raw := strings.TrimSpace(r.FormValue("category_id")); raw != "" {
+		parsed, parseErr := strconv.Atoi(raw)
+		if parseErr != nil || parsed <= 0 {
+			return productMultipartRequest{}, errors.New("invalid category_id")
+		}
+		categoryID = parsed
+	}
 
 	imageURL, hasImage, err := saveUploadedImage(r, "image", imageRequired, uploadDir)
 	if err != nil {
@@ -292,15 +483,25 @@ func parseProductMultipart(r *http.Request, imageRequired bool, uploadDir string
 	}
 
 	return productMultipartRequest{
-		ID:          id,
-		Name:        strings.TrimSpace(r.FormValue("name")),
-		Description: strings.TrimSpace(r.FormValue("description")),
-		ImageURL:    imageURL,
-		Price:       price,
-		Stock:       stock,
-		Category:    strings.TrimSpace(r.FormValue("category")),
-		Unit:        unit,
-		HasImage:    hasImage,
+		ID:                    id,
+		Name:                  strings.TrimSpace(r.FormValue("name")),
+		Description:           strings.TrimSpace(r.FormValue("description")),
+		ImageURL:              imageURL,
+		Price:                 price,
+		Currency:              strings.TrimSpace(r.FormValue("currency")),
+		Stock:                 stock,
+		CategoryID:            categoryID,
+		Category:              strings.TrimSpace(r.FormValue("category")),
+		Unit:                  unit,
+		SKU:                   strings.TrimSpace(r.FormValue("sku")),
+		Barcode:               strings.TrimSpace(r.FormValue("barcode")),
+		HasImage:              hasImage,
+		ReorderThreshold:      threshold,
+		HasStock:              rawStock != "",
+		HasReorderThreshold:   rawThreshold != "",
+		ExpiryHideDays:        expiry[0],
+		ExpiryDiscountDays:    expiry[1],
+		ExpiryDiscountPercent: expiry[2],
 	}, nil
 }
 
@@ -308,13 +509,13 @@ func normalizeProductUnit(raw string) (string, error) {
 	v := strings.TrimSpace(strings.ToLower(raw))
 	switch v {
 	case "kg":
-		return "kg", nil
+		return models.UnitKg, nil
 	case "piece", "pieces", "pcs", "pc", "shtuk", "sht", "штук", "шт":
-		return "piece", nil
+		return models.UnitPiece, nil
 	case "pack", "pachka", "пачка":
-		return "pack", nil
+		return models.UnitPack, nil
 	case "":
-		return "piece", nil
+		return models.UnitPiece, nil
 	default:
 		return "", errors.New("invalid unit (use: kg, piece, pack)")
 	}
@@ -384,13 +585,12 @@ func deleteLocalProductImage(imageURL string, uploadDir string) {
 	_ = os.Remove(filepath.Join(dir, baseName))
 }
 
-func getUserIDFromHeader(r *http.Request) (int, error) {
-	userIDStr := r.Header.Get("X-User-Id")
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil || userID <= 0 {
-		return 0, errors.New("invalid user id")
+func currentUserID(r *http.Request) (int, error) {
+	user := middleware.CurrentUser(r)
+	if user == nil {
+		return 0, errors.New("not authenticated")
 	}
-	return userID, nil
+	return user.ID, nil
 }
 
 func writeJSON(w http.ResponseWriter, status int, payload interface{}) {
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"foodstore/internal/services"
+)
+
+const maxImportBytes = int64(5 << 20) // 5MB
+
+// ImportProducts serves POST /seller/products/import?dry_run=true. The CSV
+// comes as the "file" field of a multipart form or as the request body. A
+// report with row errors is answered with 422 and nothing imported.
+func (ph *ProductHandler) ImportProducts(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	dryRun := false
+	if raw := strings.TrimSpace(r.URL.Query().Get("dry_run")); raw != "" {
+		dryRun, err = strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid dry_run")
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxFormMemoryBytes); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid multipart form")
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "file is required")
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	report, err := ph.service.ImportProducts(body, userID, dryRun)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeJSONError(w, http.StatusRequestEntityTooLarge, "the CSV file is larger than 5MB")
+		case errors.Is(err, services.ErrInvalidImport):
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			writeProductWriteError(w, err)
+		}
+		return
+	}
+	if len(report.Errors) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// ExportProducts serves GET /seller/products/export, the caller's products
+// as CSV in the import format. Users who may edit any product can export
+// another seller's with seller_id.
+func (ph *ProductHandler) ExportProducts(w http.ResponseWriter, r *http.Request) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	sellerID := userID
+	if raw := strings.TrimSpace(r.URL.Query().Get("seller_id")); raw != "" && ph.canWriteAnyProduct(r) {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid seller_id")
+			return
+		}
+		sellerID = id
+	}
+
+	var buf bytes.Buffer
+	if err := ph.service.ExportProducts(&buf, sellerID); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="products.csv"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
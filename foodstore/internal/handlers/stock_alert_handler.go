@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"foodstore/internal/middleware"
+	"foodstore/internal/policy"
+	"foodstore/internal/services"
+)
+
+type StockAlertHandler struct {
+	service *services.StockAlertService
+	policy  *policy.Policy
+}
+
+func NewStockAlertHandler(as *services.StockAlertService, pol *policy.Policy) *StockAlertHandler {
+	return &StockAlertHandler{service: as, policy: pol}
+}
+
+// ListAlerts serves GET /seller/alerts, the caller's low-stock and
+// out-of-stock alerts. Users who may edit any product see every seller's
+// alerts, or one seller's with seller_id.
+func (ah *StockAlertHandler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	sellerID, ok := ah.alertSellerID(w, r)
+	if !ok {
+		return
+	}
+	values := r.URL.Query()
+	if raw := strings.TrimSpace(values.Get("seller_id")); raw != "" && ah.canReadAnyAlert(r) {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "invalid seller_id")
+			return
+		}
+		sellerID = id
+	}
+	var limit, offset int
+	for _, param := range []struct {
+		name string
+		dst  *int
+	}{
+		{"limit", &limit},
+		{"offset", &offset},
+	} {
+		raw := strings.TrimSpace(values.Get(param.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid "+param.name)
+			return
+		}
+		*param.dst = n
+	}
+	status := strings.ToLower(strings.TrimSpace(values.Get("status")))
+	feed, err := ah.service.Feed(sellerID, status, limit, offset)
+	if err != nil {
+		writeStockAlertError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+// MarkRead serves POST /seller/alerts/{id}/read. Read state belongs to the
+// seller, so only the seller of the alert may mark it read.
+func (ah *StockAlertHandler) MarkRead(w http.ResponseWriter, r *http.Request) {
+	sellerID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid alert id")
+		return
+	}
+	if err := ah.service.MarkRead(id, sellerID); err != nil {
+		writeStockAlertError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "read"})
+}
+
+// MarkAllRead serves POST /seller/alerts/read, which marks the caller's own
+// alerts read.
+func (ah *StockAlertHandler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
+	sellerID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	marked, err := ah.service.MarkAllRead(sellerID)
+	if err != nil {
+		writeStockAlertError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"marked": marked})
+}
+
+// alertSellerID returns the seller whose alerts the caller works with: the
+// caller, or zero (every seller) for users who may edit any product.
+func (ah *StockAlertHandler) alertSellerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return 0, false
+	}
+	if ah.canReadAnyAlert(r) {
+		return 0, true
+	}
+	return userID, true
+}
+
+func (ah *StockAlertHandler) canReadAnyAlert(r *http.Request) bool {
+	user := middleware.CurrentUser(r)
+	return user != nil && ah.policy.Can(user.Role, policy.ProductWriteAny)
+}
+
+func writeStockAlertError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrStockAlertNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInvalidAlertQuery):
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
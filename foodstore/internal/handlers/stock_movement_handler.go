@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+// StockMovements serves GET /products/{id}/stock-movements, the movement
+// report of a product for whoever may edit it.
+func (ph *ProductHandler) StockMovements(w http.ResponseWriter, r *http.Request) {
+	productID, _, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	q, err := parseStockMovementQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	q.ProductID = productID
+	report, err := ph.service.StockMovements(q)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// RecordStockMovement serves POST /products/{id}/stock-movements for
+// receipts, adjustments and spoilage. A receipt may name a lot_number and
+// best_before date; batch_id picks the batch any movement applies to.
+func (ph *ProductHandler) RecordStockMovement(w http.ResponseWriter, r *http.Request) {
+	productID, userID, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	var reqBody struct {
+		VariantID  int             `json:"variant_id"`
+		BatchID    int             `json:"batch_id"`
+		Kind       string          `json:"kind"`
+		Quantity   models.Quantity `json:"quantity"`
+		Reason     string          `json:"reason"`
+		LotNumber  string          `json:"lot_number"`
+		BestBefore string          `json:"best_before"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	movement, err := ph.service.RecordStockMovement(productID, models.StockMovement{
+		VariantID:  reqBody.VariantID,
+		BatchID:    reqBody.BatchID,
+		Kind:       strings.ToLower(strings.TrimSpace(reqBody.Kind)),
+		Quantity:   reqBody.Quantity,
+		ActorID:    userID,
+		Reason:     reqBody.Reason,
+		LotNumber:  reqBody.LotNumber,
+		BestBefore: reqBody.BestBefore,
+	})
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, movement)
+}
+
+// parseStockMovementQuery reads the filters of the movement report. from and
+// to are dates (2006-01-02) or RFC 3339 times; to is exclusive.
+func parseStockMovementQuery(r *http.Request) (models.StockMovementQuery, error) {
+	values := r.URL.Query()
+	q := models.StockMovementQuery{Kind: strings.ToLower(strings.TrimSpace(values.Get("kind")))}
+	for _, param := range []struct {
+		name string
+		dst  *int
+	}{
+		{"variant_id", &q.VariantID},
+		{"limit", &q.Limit},
+		{"offset", &q.Offset},
+	} {
+		raw := strings.TrimSpace(values.Get(param.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return q, fmt.Errorf("invalid %s", param.name)
+		}
+		*param.dst = n
+	}
+	for _, param := range []struct {
+		name string
+		dst  **time.Time
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+	} {
+		raw := strings.TrimSpace(values.Get(param.name))
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			t, err = time.ParseInLocation("2006-01-02", raw, time.Local)
+		}
+		if err != nil {
+			return q, fmt.Errorf("invalid %s", param.name)
+		}
+		*param.dst = &t
+	}
+	return q, nil
+}
@@ -2,18 +2,23 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
+	"foodstore/internal/middleware"
 	"foodstore/internal/services"
 )
 
 type UserHandler struct {
-	service *services.UserService
+	service     *services.UserService
+	authService *services.AuthService
+	cartService *services.CartService
 }
 
-func NewUserHandler(us *services.UserService) *UserHandler {
-	return &UserHandler{service: us}
+func NewUserHandler(us *services.UserService, as *services.AuthService, cs *services.CartService) *UserHandler {
+	return &UserHandler{service: us, authService: as, cartService: cs}
 }
 
 func (uh *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -45,12 +50,24 @@ func (uh *UserHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, expiresAt, err := uh.issueSession(w, r, id)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to create session"})
+		return
+	}
+	uh.mergeAnonymousCart(w, r, id)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "ok",
-		"user_id": id,
-		"role":    role,
-		"message": "Registration successful",
+		"status":      "ok",
+		"user_id":     id,
+		"role":        role,
+		"permissions": uh.service.Permissions(role),
+		"token":       token,
+		"expires_at":  expiresAt,
+		"message":     "Registration successful",
 	})
 }
 
@@ -81,14 +98,26 @@ func (uh *UserHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	token, expiresAt, err := uh.issueSession(w, r, user.ID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "failed to create session"})
+		return
+	}
+	uh.mergeAnonymousCart(w, r, user.ID)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "ok",
+		"status":     "ok",
+		"token":      token,
+		"expires_at": expiresAt,
 		"user": map[string]interface{}{
-			"id":    user.ID,
-			"name":  user.Name,
-			"email": user.Email,
-			"role":  user.Role,
+			"id":          user.ID,
+			"name":        user.Name,
+			"email":       user.Email,
+			"role":        user.Role,
+			"permissions": uh.service.Permissions(user.Role),
 		},
 	})
 }
@@ -137,3 +166,104 @@ func (uh *UserHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 		},
 	})
 }
+
+func (uh *UserHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	if session := middleware.CurrentSession(r); session != nil {
+		if err := uh.authService.RevokeSession(session.ID); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	clearSessionCookie(w, r)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "logged_out"})
+}
+
+func (uh *UserHandler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	revoked, err := uh.authService.RevokeUserSessions(userID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	clearSessionCookie(w, r)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "logged_out", "revoked": revoked})
+}
+
+func (uh *UserHandler) RevokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var reqBody struct {
+		UserID int `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil || reqBody.UserID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+
+	revoked, err := uh.authService.RevokeUserSessions(reqBody.UserID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "revoked", "user_id": reqBody.UserID, "revoked": revoked})
+}
+
+func (uh *UserHandler) issueSession(w http.ResponseWriter, r *http.Request, userID int) (string, time.Time, error) {
+	token, expiresAt, err := uh.authService.CreateSession(userID, r.UserAgent())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return token, expiresAt, nil
+}
+
+// mergeAnonymousCart folds the visitor's anonymous cart into their own cart.
+// A failed merge does not fail the login; the anonymous cart is kept.
+func (uh *UserHandler) mergeAnonymousCart(w http.ResponseWriter, r *http.Request, userID int) {
+	cookie, err := r.Cookie(cartCookieName)
+	if err != nil || cookie.Value == "" {
+		return
+	}
+	if err := uh.cartService.MergeAnonymousCart(cookie.Value, userID); err != nil {
+		log.Printf("cart merge for user %d failed: %v", userID, err)
+		return
+	}
+	clearCartCookie(w, r)
+}
+
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     middleware.SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"foodstore/internal/models"
+)
+
+type variantRequest struct {
+	SKU     string           `json:"sku"`
+	Name    string           `json:"name"`
+	Price   models.Money     `json:"price"`
+	Stock   *models.Quantity `json:"stock"`
+	Unit    string           `json:"unit"`
+	Barcode string           `json:"barcode"`
+}
+
+// ListVariants serves GET /products/{id}/variants.
+func (ph *ProductHandler) ListVariants(w http.ResponseWriter, r *http.Request) {
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid product id")
+		return
+	}
+	variants, err := ph.service.ListVariants(productID)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, variants)
+}
+
+func (ph *ProductHandler) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, userID, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	v, hasStock, err := decodeVariantRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !hasStock {
+		writeJSONError(w, http.StatusBadRequest, "stock is required")
+		return
+	}
+	created, err := ph.service.CreateVariant(productID, v, userID)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, created)
+}
+
+// UpdateVariant serves PUT /products/{id}/variants/{variant_id}. An empty
+// sku keeps the current one; stock cannot be set here.
+func (ph *ProductHandler) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	productID, _, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	variantID, err := strconv.Atoi(r.PathValue("variant_id"))
+	if err != nil || variantID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid variant id")
+		return
+	}
+	v, hasStock, err := decodeVariantRequest(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if hasStock {
+		writeJSONError(w, http.StatusBadRequest, errStockEdit)
+		return
+	}
+	v.ID = variantID
+	updated, err := ph.service.UpdateVariant(productID, v)
+	if err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (ph *ProductHandler) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	productID, userID, ok := ph.ownedProductID(w, r)
+	if !ok {
+		return
+	}
+	variantID, err := strconv.Atoi(r.PathValue("variant_id"))
+	if err != nil || variantID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid variant id")
+		return
+	}
+	if err := ph.service.DeleteVariant(productID, variantID, userID); err != nil {
+		writeProductWriteError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// ownedProductID reads the {id} path value and checks the caller may edit
+// that product, writing the error response when not. It returns the product
+// and the caller's user ID.
+func (ph *ProductHandler) ownedProductID(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	userID, err := currentUserID(r)
+	if err != nil {
+		writeJSONError(w, http.StatusUnauthorized, "authentication required")
+		return 0, 0, false
+	}
+	productID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || productID <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid product id")
+		return 0, 0, false
+	}
+	existing, err := ph.service.GetProductByID(productID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSONError(w, http.StatusNotFound, "product not found")
+			return 0, 0, false
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return 0, 0, false
+	}
+	if !ph.canWriteAnyProduct(r) && existing.SellerID != userID {
+		writeJSONError(w, http.StatusForbidden, "you can edit only your own products")
+		return 0, 0, false
+	}
+	return productID, userID, true
+}
+
+// decodeVariantRequest reads a variant from the JSON body and reports
+// whether it set stock.
+func decodeVariantRequest(r *http.Request) (models.ProductVariant, bool, error) {
+	var reqBody variantRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		return models.ProductVariant{}, false, errors.New("invalid request body")
+	}
+	unit, err := normalizeProductUnit(reqBody.Unit)
+	if err != nil {
+		return models.ProductVariant{}, false, err
+	}
+	var stock models.Quantity
+	if reqBody.Stock != nil {
+		stock = *reqBody.Stock
+	}
+	if rule := models.RuleForUnit(unit); stock >= 0 && !rule.OnStep(stock) {
+		return models.ProductVariant{}, false, fmt.Errorf("stock must be a multiple of %s for unit %s", rule.Step, unit)
+	}
+	return models.ProductVariant{
+		SKU:     reqBody.SKU,
+		Name:    reqBody.Name,
+		Price:   reqBody.Price,
+		Stock:   stock,
+		Unit:    unit,
+		Barcode: reqBody.Barcode,
+	}, reqBody.Stock != nil, nil
+}
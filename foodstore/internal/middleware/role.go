@@ -3,18 +3,19 @@ package middleware
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
-	"foodstore/internal/services"
+	"foodstore/internal/policy"
 )
 
-func RequireSeller(us *services.UserService, next http.Handler) http.Handler {
+// RequirePermissionForWrites lets reads through and enforces perm on
+// POST, PUT and DELETE.
+func RequirePermissionForWrites(pol *policy.Policy, perm policy.Permission, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodDelete {
 			next.ServeHTTP(w, r)
 			return
 		}
-		if !requireSellerUser(w, r, us) {
+		if !requirePermission(w, r, pol, perm) {
 			return
 		}
 
@@ -22,9 +23,9 @@ func RequireSeller(us *services.UserService, next http.Handler) http.Handler {
 	})
 }
 
-func RequireSellerStrict(us *services.UserService, next http.Handler) http.Handler {
+func RequirePermission(pol *policy.Policy, perm policy.Permission, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !requireSellerUser(w, r, us) {
+		if !requirePermission(w, r, pol, perm) {
 			return
 		}
 
@@ -32,28 +33,19 @@ func RequireSellerStrict(us *services.UserService, next http.Handler) http.Handl
 	})
 }
 
-func requireSellerUser(w http.ResponseWriter, r *http.Request, us *services.UserService) bool {
-	userIDStr := r.Header.Get("X-User-Id")
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil || userID <= 0 {
+func requirePermission(w http.ResponseWriter, r *http.Request, pol *policy.Policy, perm policy.Permission) bool {
+	user := CurrentUser(r)
+	if user == nil {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "missing or invalid user id"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "authentication required"})
 		return false
 	}
 
-	user, err := us.GetUserByID(userID)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "user not found"})
-		return false
-	}
-
-	if user.Role != "seller" && user.Role != "administrator" {
+	if !pol.Can(user.Role, perm) {
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(map[string]string{"error": "seller or administrator role required"})
+		json.NewEncoder(w).Encode(map[string]string{"error": "permission required: " + string(perm)})
 		return false
 	}
 
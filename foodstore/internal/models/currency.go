@@ -0,0 +1,133 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidCurrency = errors.New("invalid currency code")
+	ErrInvalidRate     = errors.New("invalid exchange rate")
+)
+
+// Rate is an exchange rate with eight decimals: how many units of the base
+// currency one unit of another currency is worth. It maps to NUMERIC(18,8).
+type Rate int64
+
+const rateScale = 100_000_000
+
+// BaseRate is the rate of the base currency against itself.
+const BaseRate = Rate(rateScale)
+
+// ExchangeRate is an admin-managed rate of one currency against the base.
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      Rate      `json:"rate"`
+	UpdatedBy int       `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NormalizeCurrency upper-cases a three-letter ISO 4217 code.
+func NormalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", ErrInvalidCurrency
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", ErrInvalidCurrency
+		}
+	}
+	return code, nil
+}
+
+func ParseRate(s string) (Rate, error) {
+	v, err := parseFixed(s, 8)
+	if err != nil || v <= 0 {
+		return 0, ErrInvalidRate
+	}
+	return Rate(v), nil
+}
+
+// Convert turns an amount priced in a currency worth from base units into a
+// currency worth to base units, rounded half away from zero to minor units.
+func (m Money) Convert(from, to Rate) Money {
+	if from == to {
+		return m
+	}
+	num := new(big.Int).Mul(big.NewInt(int64(m)), big.NewInt(int64(from)))
+	den := big.NewInt(int64(to))
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return Money(quo.Int64())
+}
+
+func (r Rate) String() string {
+	s := fmt.Sprintf("%d", int64(r)/rateScale)
+	if frac := int64(r) % rateScale; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%08d", frac), "0")
+	}
+	return s
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string.
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	raw := strings.TrimSpace(string(data))
+	if raw == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(raw); err == nil {
+		raw = unquoted
+	}
+	parsed, err := ParseRate(raw)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r *Rate) Scan(src any) error {
+	var s string
+	switch v := src.(type) {
+	case nil:
+		*r = BaseRate
+		return nil
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*r = Rate(v * rateScale)
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', 8, 64)
+	default:
+		return fmt.Errorf("cannot scan %T into Rate", src)
+	}
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return fmt.Errorf("scan rate %q: %w", s, err)
+	}
+	*r = parsed
+	return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
@@ -6,44 +6,204 @@ type User struct {
 	ID           int       `json:"id"`
 	Name         string    `json:"name"`
 	Email        string    `json:"email"`
-	PasswordHash string    `json:"password_hash"`
+	PasswordHash string    `json:"-"`
 	Role         string    `json:"role"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Product.Category is the name of the category CategoryID, kept on the row
+// for search. A product is sold as one or more Variants; Price, Stock and
+// Unit summarise them for listings: the lowest price, the stock on hand of
+// all variants together and the unit of the first variant. Available is
+// what buyers can still order: the stock of batches still on sale minus
+// active checkout holds. A variant whose stock falls to ReorderThreshold or
+// below raises a low-stock alert for the seller; zero disables it. Expiry
+// sets how batches near their best-before date are sold. A deleted product
+// is archived (ArchivedAt set): it leaves the catalog and is no longer sold,
+// but orders still refer to it.
 type Product struct {
-	ID          int       `json:"id"`
-	SellerID    int       `json:"seller_id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	ImageURL    string    `json:"image_url"`
-	Price       float64   `json:"price"`
-	Stock       int       `json:"stock"`
-	Category    string    `json:"category"`
-	Unit        string    `json:"unit"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID               int          `json:"id"`
+	SellerID         int          `json:"seller_id"`
+	Name             string       `json:"name"`
+	Description      string       `json:"description"`
+	ImageURL         string       `json:"image_url"`
+	Price            Money        `json:"price"`
+	Currency         string       `json:"currency"`
+	Stock            Quantity     `json:"stock"`
+	Available        Quantity     `json:"available"`
+	CategoryID       int          `json:"category_id"`
+	Category         string       `json:"category"`
+	Unit             string       `json:"unit"`
+	ReorderThreshold Quantity     `json:"reorder_threshold"`
+	Expiry           ExpiryPolicy `json:"expiry"`
+	CreatedAt        time.Time    `json:"created_at"`
+	ArchivedAt       *time.Time   `json:"archived_at,omitempty"`
+	// DisplayPrice is Price converted into DisplayCurrency for listings.
+	DisplayPrice    *Money           `json:"display_price,omitempty"`
+	DisplayCurrency string           `json:"display_currency,omitempty"`
+	Variants        []ProductVariant `json:"variants"`
+}
+
+// ExpiryPolicy says how a product's batches are sold as their best-before
+// date nears. A batch is sold DiscountPercent off from DiscountDays before
+// the date and taken off sale HideDays before it; with HideDays zero it is
+// sold up to and including the date.
+type ExpiryPolicy struct {
+	HideDays        int `json:"hide_days"`
+	DiscountDays    int `json:"discount_days"`
+	DiscountPercent int `json:"discount_percent"`
+}
+
+// ProductVariant is one sellable version of a product ("1 L", "2 L") with
+// its own SKU, price, stock and unit. Prices are in the product's currency.
+// The first variant by Position is the product's default variant.
+// Discounted is the part of Available sold DiscountPercent off, at
+// DiscountPrice, because its batches are near their best-before date.
+type ProductVariant struct {
+	ID                   int       `json:"id"`
+	ProductID            int       `json:"product_id"`
+	SKU                  string    `json:"sku"`
+	Name                 string    `json:"name"`
+	Price                Money     `json:"price"`
+	Stock                Quantity  `json:"stock"`
+	Available            Quantity  `json:"available"`
+	Unit                 string    `json:"unit"`
+	Barcode              string    `json:"barcode,omitempty"`
+	Position             int       `json:"position"`
+	CreatedAt            time.Time `json:"created_at"`
+	DisplayPrice         *Money    `json:"display_price,omitempty"`
+	Discounted           Quantity  `json:"discounted,omitempty"`
+	DiscountPercent      int       `json:"discount_percent,omitempty"`
+	DiscountPrice        *Money    `json:"discount_price,omitempty"`
+	DisplayDiscountPrice *Money    `json:"display_discount_price,omitempty"`
+}
+
+// Category is a node of the category tree. ProductCount counts products
+// filed directly under it, TotalCount those in its whole subtree.
+type Category struct {
+	ID           int         `json:"id"`
+	ParentID     int         `json:"parent_id,omitempty"`
+	Name         string      `json:"name"`
+	Slug         string      `json:"slug"`
+	ProductCount int         `json:"product_count"`
+	TotalCount   int         `json:"total_product_count"`
+	Children     []*Category `json:"children,omitempty"`
+	CreatedAt    time.Time   `json:"created_at"`
 }
 
+const (
+	ProductSortNewest    = "newest"
+	ProductSortPriceAsc  = "price_asc"
+	ProductSortPriceDesc = "price_desc"
+	ProductSortNameAsc   = "name_asc"
+	ProductSortNameDesc  = "name_desc"
+	// ProductSortRelevance ranks full-text search results; it needs Search.
+	ProductSortRelevance = "relevance"
+)
+
+// ProductQuery filters, sorts and pages the catalogue. Zero values mean no
+// filter. CategoryID, or else Category as a slug or name, selects a
+// category together with its subcategories. MinPrice and MaxPrice are in
+// the base currency. Search is a to_tsquery expression matched against the
+// product search vector. Archived lists archived products instead of the
+// catalogue.
+type ProductQuery struct {
+	Search     string
+	CategoryID int
+	Category   string
+	SellerID   int
+	Archived   bool
+	MinPrice   *Money
+	MaxPrice   *Money
+	InStock    bool
+	Sort       string
+	Limit      int
+	Offset     int
+}
+
+// ProductPage is one page of a product listing. Total counts every product
+// matching the filters.
+type ProductPage struct {
+	Items   []Product `json:"items"`
+	Total   int       `json:"total"`
+	Limit   int       `json:"limit"`
+	Offset  int       `json:"offset"`
+	HasMore bool      `json:"has_more"`
+}
+
+const (
+	OrderStatusPending   = "pending"
+	OrderStatusConfirmed = "confirmed"
+	OrderStatusPacked    = "packed"
+	OrderStatusShipped   = "shipped"
+	OrderStatusDelivered = "delivered"
+	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
+)
+
 type Order struct {
-	ID              int         `json:"id"`
-	UserID          int         `json:"user_id"`
-	TotalPrice      float64     `json:"total_price"`
-	Status          string      `json:"status"`
-	DeliveryAddress string      `json:"delivery_address"`
-	PhoneNumber     string      `json:"phone_number"`
-	Comment         string      `json:"comment"`
-	CreatedAt       time.Time   `json:"created_at"`
-	Items           []OrderItem `json:"items,omitempty"`
+	ID              int                 `json:"id"`
+	UserID          int                 `json:"user_id"`
+	TotalPrice      Money               `json:"total_price"`
+	Currency        string              `json:"currency"`
+	ExchangeRate    Rate                `json:"exchange_rate"`
+	Status          string              `json:"status"`
+	DeliveryAddress string              `json:"delivery_address"`
+	PhoneNumber     string              `json:"phone_number"`
+	Comment         string              `json:"comment"`
+	CreatedAt       time.Time           `json:"created_at"`
+	CancelledBy     int                 `json:"cancelled_by,omitempty"`
+	CancelledAt     *time.Time          `json:"cancelled_at,omitempty"`
+	CancelReason    string              `json:"cancel_reason,omitempty"`
+	Items           []OrderItem         `json:"items,omitempty"`
+	Fulfillments    []OrderFulfillment  `json:"fulfillments,omitempty"`
+	History         []OrderStatusChange `json:"history,omitempty"`
 }
 
+// OrderFulfillment is one seller's part of an order. Each part moves through
+// the order lifecycle on its own; the order status is derived from its parts.
+type OrderFulfillment struct {
+	ID         int       `json:"id"`
+	OrderID    int       `json:"order_id"`
+	SellerID   int       `json:"seller_id"`
+	SellerName string    `json:"seller_name"`
+	Status     string    `json:"status"`
+	Total      Money     `json:"total"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type OrderStatusChange struct {
+	ID         int       `json:"id"`
+	OrderID    int       `json:"order_id"`
+	SellerID   int       `json:"seller_id,omitempty"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	ActorID    int       `json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	Note       string    `json:"note"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OrderItem carries a snapshot of the product and variant (name, SKU, unit,
+// image) taken when the order was placed, so later product edits do not
+// rewrite past orders. VariantID is zero once the variant is deleted.
+// DiscountPercent is the expiry discount included in UnitPrice.
 type OrderItem struct {
-	ID          int     `json:"id"`
-	OrderID     int     `json:"order_id"`
-	ProductID   int     `json:"product_id"`
-	Quantity    int     `json:"quantity"`
-	UnitPrice   float64 `json:"unit_price"`
-	LineTotal   float64 `json:"line_total"`
-	ProductName string  `json:"name"`
+	ID              int      `json:"id"`
+	OrderID         int      `json:"order_id"`
+	ProductID       int      `json:"product_id"`
+	VariantID       int      `json:"variant_id"`
+	SKU             string   `json:"sku"`
+	VariantName     string   `json:"variant_name"`
+	SellerID        int      `json:"seller_id"`
+	Quantity        Quantity `json:"quantity"`
+	UnitPrice       Money    `json:"unit_price"`
+	DiscountPercent int      `json:"discount_percent,omitempty"`
+	LineTotal       Money    `json:"line_total"`
+	ProductName     string   `json:"name"`
+	ProductUnit     string   `json:"unit"`
+	ProductImageURL string   `json:"image_url"`
 }
 
 type ContactMessage struct {
@@ -58,15 +218,270 @@ type ContactMessage struct {
 }
 
 type SellerOrder struct {
-	ID              int         `json:"id"`
-	UserID          int         `json:"user_id"`
-	BuyerName       string      `json:"buyer_name"`
-	BuyerEmail      string      `json:"buyer_email"`
-	Status          string      `json:"status"`
-	DeliveryAddress string      `json:"delivery_address"`
-	PhoneNumber     string      `json:"phone_number"`
-	Comment         string      `json:"comment"`
-	SellerTotal     float64     `json:"seller_total"`
-	CreatedAt       time.Time   `json:"created_at"`
-	Items           []OrderItem `json:"items,omitempty"`
+	ID              int                 `json:"id"`
+	UserID          int                 `json:"user_id"`
+	BuyerName       string              `json:"buyer_name"`
+	BuyerEmail      string              `json:"buyer_email"`
+	FulfillmentID   int                 `json:"fulfillment_id"`
+	Status          string              `json:"status"`
+	OrderStatus     string              `json:"order_status"`
+	DeliveryAddress string              `json:"delivery_address"`
+	PhoneNumber     string              `json:"phone_number"`
+	Comment         string              `json:"comment"`
+	SellerTotal     Money               `json:"seller_total"`
+	Currency        string              `json:"currency"`
+	CreatedAt       time.Time           `json:"created_at"`
+	Items           []OrderItem         `json:"items,omitempty"`
+	NextStatuses    []string            `json:"next_statuses"`
+	History         []OrderStatusChange `json:"history,omitempty"`
+}
+
+const (
+	CartIssuePriceChanged      = "price_changed"
+	CartIssueInsufficientStock = "insufficient_stock"
+	CartIssueOutOfStock        = "out_of_stock"
+)
+
+// Cart is a server-side shopping cart, owned by a user or, before login, by
+// an anonymous cart token. Prices are shown in the base currency.
+// ReservedUntil is set while the user holds stock for checkout.
+type Cart struct {
+	ID            int        `json:"id"`
+	UserID        int        `json:"user_id,omitempty"`
+	Currency      string     `json:"currency"`
+	Items         []CartItem `json:"items"`
+	Total         Money      `json:"total"`
+	Valid         bool       `json:"valid"`
+	ReservedUntil *time.Time `json:"reserved_until,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// CartItem is revalidated on every read: Price and Stock are current, and
+// Issues lists what changed since the item was added at AddedPrice. Stock is
+// what the cart owner can buy: on hand minus other buyers' holds.
+type CartItem struct {
+	ProductID       int       `json:"product_id"`
+	VariantID       int       `json:"variant_id"`
+	SKU             string    `json:"sku"`
+	VariantName     string    `json:"variant_name"`
+	SellerID        int       `json:"seller_id"`
+	Name            string    `json:"name"`
+	ImageURL        string    `json:"image_url"`
+	Unit            string    `json:"unit"`
+	Quantity        Quantity  `json:"quantity"`
+	AddedPrice      Money     `json:"added_price"`
+	Price           Money     `json:"price"`
+	LineTotal       Money     `json:"line_total"`
+	Stock           Quantity  `json:"stock"`
+	Issues          []string  `json:"issues,omitempty"`
+	AddedAt         time.Time `json:"added_at"`
+	ProductCurrency string    `json:"-"`
+}
+
+// StockReservation holds a quantity of a product variant for a buyer's
+// checkout until ExpiresAt.
+type StockReservation struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	ProductID int       `json:"product_id"`
+	VariantID int       `json:"variant_id"`
+	Quantity  Quantity  `json:"quantity"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+const (
+	StockMovementReceipt      = "receipt"
+	StockMovementSale         = "sale"
+	StockMovementCancellation = "cancellation"
+	StockMovementAdjustment   = "adjustment"
+	StockMovementSpoilage     = "spoilage"
+)
+
+// StockMovement is an entry of the append-only stock ledger. Quantity is the
+// signed change to the variant's stock and StockAfter the stock it left.
+// Variant stock only changes through movements, so the movements of a
+// variant add up to its stock. Each entry changes one batch; SKU and
+// LotNumber are kept when the variant or batch is deleted. BestBefore
+// (2006-01-02) is the batch's date, or for a receipt of a new lot the date
+// to give it.
+type StockMovement struct {
+	ID         int       `json:"id"`
+	ProductID  int       `json:"product_id"`
+	VariantID  int       `json:"variant_id,omitempty"`
+	SKU        string    `json:"sku"`
+	BatchID    int       `json:"batch_id,omitempty"`
+	LotNumber  string    `json:"lot_number,omitempty"`
+	BestBefore string    `json:"best_before,omitempty"`
+	Kind       string    `json:"kind"`
+	Quantity   Quantity  `json:"quantity"`
+	StockAfter Quantity  `json:"stock_after"`
+	ActorID    int       `json:"actor_id,omitempty"`
+	ActorName  string    `json:"actor_name,omitempty"`
+	OrderID    int       `json:"order_id,omitempty"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// StockMovementQuery filters and pages the movements of a product. Zero
+// values mean no filter; From is inclusive and To exclusive.
+type StockMovementQuery struct {
+	ProductID int
+	VariantID int
+	Kind      string
+	From      *time.Time
+	To        *time.Time
+	Limit     int
+	Offset    int
+}
+
+// StockMovementReport is one page of a product's movements, newest first.
+// Totals adds up the quantities of every matching movement by kind and Net
+// is their sum.
+type StockMovementReport struct {
+	ProductID int                 `json:"product_id"`
+	Items     []StockMovement     `json:"items"`
+	Totals    map[string]Quantity `json:"totals"`
+	Net       Quantity            `json:"net"`
+	Total     int                 `json:"total"`
+	Limit     int                 `json:"limit"`
+	Offset    int                 `json:"offset"`
+	HasMore   bool                `json:"has_more"`
+}
+
+const (
+	StockAlertLowStock   = "low_stock"
+	StockAlertOutOfStock = "out_of_stock"
+)
+
+// StockAlert tells a seller that a variant's sellable stock fell to its
+// product's reorder threshold (low_stock) or to zero (out_of_stock). Stock
+// and Threshold are the values when the alert was raised; the alert is
+// resolved once the variant is restocked above them.
+type StockAlert struct {
+	ID           int        `json:"id"`
+	SellerID     int        `json:"seller_id"`
+	ProductID    int        `json:"product_id"`
+	ProductName  string     `json:"product_name"`
+	VariantID    int        `json:"variant_id"`
+	SKU          string     `json:"sku"`
+	VariantName  string     `json:"variant_name"`
+	Unit         string     `json:"unit"`
+	Kind         string     `json:"kind"`
+	Stock        Quantity   `json:"stock"`
+	Threshold    Quantity   `json:"threshold"`
+	CurrentStock Quantity   `json:"current_stock"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ReadAt       *time.Time `json:"read_at,omitempty"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+// StockAlertFeed is one page of a seller's alerts, newest first. Unread
+// counts the open alerts not yet marked read.
+type StockAlertFeed struct {
+	Items   []StockAlert `json:"items"`
+	Unread  int          `json:"unread"`
+	Total   int          `json:"total"`
+	Limit   int          `json:"limit"`
+	Offset  int          `json:"offset"`
+	HasMore bool         `json:"has_more"`
+}
+
+// IdempotencyKey remembers a request made with an Idempotency-Key header so
+// a retry gets the original response. StatusCode and Response are empty
+// while the first request is still being processed. OrderID is the order
+// the request placed, recorded in the order's own transaction, so it is set
+// even when storing the response failed.
+type IdempotencyKey struct {
+	UserID      int
+	Key         string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	OrderID     int
+	CreatedAt   time.Time
+	CompletedAt *time.Time
+}
+
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	TokenHash  string     `json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+const (
+	BatchStatusOnSale     = "on_sale"
+	BatchStatusDiscounted = "discounted"
+	BatchStatusHidden     = "hidden"
+	BatchStatusExpired    = "expired"
+)
+
+// StockBatch is stock of a variant received under one lot number and
+// best-before date (2006-01-02). The batch with an empty LotNumber holds
+// stock of no particular lot and never expires. DaysLeft counts the days to
+// the best-before date, negative once it has passed. Status is on_sale,
+// discounted or hidden by the product's ExpiryPolicy, or expired.
+type StockBatch struct {
+	ID          int       `json:"id"`
+	ProductID   int       `json:"product_id"`
+	ProductName string    `json:"product_name,omitempty"`
+	SellerID    int       `json:"seller_id,omitempty"`
+	VariantID   int       `json:"variant_id"`
+	SKU         string    `json:"sku"`
+	VariantName string    `json:"variant_name"`
+	Unit        string    `json:"unit"`
+	LotNumber   string    `json:"lot_number"`
+	BestBefore  string    `json:"best_before,omitempty"`
+	DaysLeft    *int      `json:"days_left,omitempty"`
+	Quantity    Quantity  `json:"quantity"`
+	Status      string    `json:"status"`
+	ReceivedAt  time.Time `json:"received_at"`
+}
+
+// ExpiryReport lists the batches with stock whose best-before date falls
+// within the next Days days, first-expiring first, including those already
+// past it.
+type ExpiryReport struct {
+	Days  int          `json:"days"`
+	Items []StockBatch `json:"items"`
+}
+
+const (
+	ProductImportCreate = "create"
+	ProductImportUpdate = "update"
+)
+
+// ProductImport reports a CSV product import. Every row is validated first;
+// nothing is written when any row has errors or on a dry run, and Applied
+// tells whether the rows were written.
+type ProductImport struct {
+	DryRun  bool                 `json:"dry_run"`
+	Applied bool                 `json:"applied"`
+	Created int                  `json:"created"`
+	Updated int                  `json:"updated"`
+	Rows    []ProductImportRow   `json:"rows"`
+	Errors  []ProductImportError `json:"errors"`
+}
+
+// ProductImportRow is what the import does with one CSV row: create a
+// product or update the seller's variant with that SKU and its product.
+// ProductID is known for updates and once applied.
+type ProductImportRow struct {
+	Line      int    `json:"line"`
+	SKU       string `json:"sku,omitempty"`
+	Action    string `json:"action"`
+	ProductID int    `json:"product_id,omitempty"`
+}
+
+// ProductImportError is a validation error of one CSV row, naming the
+// column when it is about one.
+type ProductImportError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidMoney   = errors.New("invalid money amount")
+	errInvalidDecimal = errors.New("invalid decimal")
+)
+
+// Money is an exact amount in minor units (1 tenge = 100 tiyn). It is read
+// from and written to NUMERIC(12,2) columns as decimal text and serialized to
+// JSON as a number with two decimals, so no float rounding happens anywhere.
+type Money int64
+
+const moneyScale = 100
+
+// ParseMoney parses a decimal amount such as "1250", "12.5" or "-0.99".
+// More than two decimals are rejected unless the extra digits are zeros.
+func ParseMoney(s string) (Money, error) {
+	v, err := parseFixed(s, 2)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+	return Money(v), nil
+}
+
+// parseFixed parses a decimal string into an integer scaled by 10^decimals
+// without going through float64.
+func parseFixed(s string, decimals int) (int64, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		negative = true
+		s = rest
+	} else {
+		s = strings.TrimPrefix(s, "+")
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return 0, errInvalidDecimal
+	}
+	if whole == "" {
+		whole = "0"
+	}
+	if len(frac) > decimals {
+		if strings.Trim(frac[decimals:], "0") != "" {
+			return 0, errInvalidDecimal
+		}
+		frac = frac[:decimals]
+	}
+	frac += strings.Repeat("0", decimals-len(frac))
+	if !isDigits(whole) || !isDigits(frac) || len(whole) > 15 {
+		return 0, errInvalidDecimal
+	}
+
+	v, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errInvalidDecimal
+	}
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Mul returns the amount for quantity units, rounded half away from zero to
+// the nearest tiyn.
+func (m Money) Mul(quantity Quantity) Money {
+	product := int64(m) * int64(quantity)
+	rounded := (abs64(product) + quantityScale/2) / quantityScale
+	if product < 0 {
+		rounded = -rounded
+	}
+	return Money(rounded)
+}
+
+// PercentOff returns the amount less percent per cent, rounded half away
+// from zero to the nearest tiyn.
+func (m Money) PercentOff(percent int) Money {
+	product := int64(m) * int64(100-percent)
+	rounded := (abs64(product) + 50) / 100
+	if product < 0 {
+		rounded = -rounded
+	}
+	return Money(rounded)
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/moneyScale, v%moneyScale)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string. The number is
+// parsed from its text, never through float64.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	raw := strings.TrimSpace(string(data))
+	if raw == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(raw); err == nil {
+		raw = unquoted
+	}
+	parsed, err := ParseMoney(raw)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+		return nil
+	case []byte:
+		return m.scanText(string(v))
+	case string:
+		return m.scanText(v)
+	case int64:
+		*m = Money(v * moneyScale)
+		return nil
+	case float64:
+		return m.scanText(strconv.FormatFloat(v, 'f', 2, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+}
+
+func (m *Money) scanText(s string) error {
+	parsed, err := ParseMoney(s)
+	if err != nil {
+		return fmt.Errorf("scan money %q: %w", s, err)
+	}
+	*m = parsed
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
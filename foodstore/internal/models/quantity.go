@@ -0,0 +1,137 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidQuantity = errors.New("invalid quantity")
+
+// Quantity is an exact amount of a product in thousandths of its unit, so
+// 0.5 kg is 500 and 3 pieces is 3000. It maps to NUMERIC(12,3) columns and
+// to plain JSON numbers ("0.5", "3").
+type Quantity int64
+
+const quantityScale = 1000
+
+const (
+	UnitKg    = "kg"
+	UnitPiece = "piece"
+	UnitPack  = "pack"
+)
+
+// UnitRule describes which quantities of a unit can be ordered: at least Min
+// and in multiples of Step. Stock is kept in multiples of Step too.
+type UnitRule struct {
+	Step Quantity `json:"step"`
+	Min  Quantity `json:"min"`
+}
+
+var unitRules = map[string]UnitRule{
+	UnitKg:    {Step: 100, Min: 100},
+	UnitPiece: {Step: quantityScale, Min: quantityScale},
+	UnitPack:  {Step: quantityScale, Min: quantityScale},
+}
+
+// RuleForUnit returns the rule of unit, falling back to whole pieces for
+// unknown units.
+func RuleForUnit(unit string) UnitRule {
+	if rule, ok := unitRules[unit]; ok {
+		return rule
+	}
+	return unitRules[UnitPiece]
+}
+
+// AllowsOrder reports whether q is a valid order quantity under the rule.
+func (r UnitRule) AllowsOrder(q Quantity) bool {
+	return q >= r.Min && r.OnStep(q)
+}
+
+// OnStep reports whether q is a non-negative multiple of the step.
+func (r UnitRule) OnStep(q Quantity) bool {
+	return q >= 0 && q%r.Step == 0
+}
+
+// Units builds a quantity from a whole number of units.
+func Units(n int) Quantity {
+	return Quantity(n) * quantityScale
+}
+
+// ParseQuantity parses a decimal quantity with up to three decimals.
+func ParseQuantity(s string) (Quantity, error) {
+	v, err := parseFixed(s, 3)
+	if err != nil {
+		return 0, ErrInvalidQuantity
+	}
+	return Quantity(v), nil
+}
+
+func (q Quantity) String() string {
+	sign := ""
+	v := int64(q)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	s := fmt.Sprintf("%s%d", sign, v/quantityScale)
+	if frac := v % quantityScale; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%03d", frac), "0")
+	}
+	return s
+}
+
+func (q Quantity) MarshalJSON() ([]byte, error) {
+	return []byte(q.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string.
+func (q *Quantity) UnmarshalJSON(data []byte) error {
+	raw := strings.TrimSpace(string(data))
+	if raw == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(raw); err == nil {
+		raw = unquoted
+	}
+	parsed, err := ParseQuantity(raw)
+	if err != nil {
+		return err
+	}
+	*q = parsed
+	return nil
+}
+
+func (q *Quantity) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*q = 0
+		return nil
+	case []byte:
+		return q.scanText(string(v))
+	case string:
+		return q.scanText(v)
+	case int64:
+		*q = Quantity(v * quantityScale)
+		return nil
+	case float64:
+		return q.scanText(strconv.FormatFloat(v, 'f', 3, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into Quantity", src)
+	}
+}
+
+func (q *Quantity) scanText(s string) error {
+	parsed, err := ParseQuantity(s)
+	if err != nil {
+		return fmt.Errorf("scan quantity %q: %w", s, err)
+	}
+	*q = parsed
+	return nil
+}
+
+func (q Quantity) Value() (driver.Value, error) {
+	return q.String(), nil
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type Permission string
+
+const (
+	ProductWriteOwn  Permission = "product:write:own"
+	ProductWriteAny  Permission = "product:write:any"
+	OrderReadSeller  Permission = "order:read:seller"
+	OrderReadAny     Permission = "order:read:any"
+	OrderStatusOwn   Permission = "order:status:own"
+	OrderStatusAny   Permission = "order:status:any"
+	ContactRead      Permission = "contact:read"
+	SessionRevokeAny Permission = "session:revoke:any"
+	RatesManage      Permission = "currency:rates:manage"
+	CategoryManage   Permission = "category:manage"
+)
+
+const DefaultRole = "buyer"
+
+// Role describes what users holding it may do. SelfRegister controls whether
+// the role can be picked on the public registration form.
+type Role struct {
+	Name         string       `json:"name"`
+	SelfRegister bool         `json:"self_register"`
+	Permissions  []Permission `json:"permissions"`
+}
+
+// Policy maps role names to permissions. Routes and services ask the policy
+// instead of comparing role strings, so a new role only needs a new entry.
+type Policy struct {
+	roles map[string]Role
+}
+
+var defaultRoles = []Role{
+	{Name: "buyer", SelfRegister: true},
+	{Name: "seller", SelfRegister: true, Permissions: []Permission{ProductWriteOwn, OrderReadSeller, OrderStatusOwn}},
+	{Name: "administrator", Permissions: []Permission{ProductWriteOwn, ProductWriteAny, OrderReadSeller, OrderReadAny, OrderStatusOwn, OrderStatusAny, ContactRead, SessionRevokeAny, RatesManage, CategoryManage}},
+}
+
+func New(roles []Role) (*Policy, error) {
+	p := &Policy{roles: make(map[string]Role, len(roles))}
+	for _, role := range roles {
+		name := strings.TrimSpace(role.Name)
+		if name == "" {
+			return nil, errors.New("policy: role name is required")
+		}
+		if _, dup := p.roles[name]; dup {
+			return nil, fmt.Errorf("policy: duplicate role %q", name)
+		}
+		role.Name = name
+		p.roles[name] = role
+	}
+	if _, ok := p.roles[DefaultRole]; !ok {
+		return nil, fmt.Errorf("policy: default role %q must be defined", DefaultRole)
+	}
+	return p, nil
+}
+
+func Default() *Policy {
+	p, _ := New(defaultRoles)
+	return p
+}
+
+// Load reads a JSON array of roles from path. The file replaces the built-in
+// roles entirely; an empty path returns the default policy.
+func Load(path string) (*Policy, error) {
+	if strings.TrimSpace(path) == "" {
+		return Default(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roles []Role
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("policy: %s: %w", path, err)
+	}
+	return New(roles)
+}
+
+func (p *Policy) Can(role string, perm Permission) bool {
+	r, ok := p.roles[role]
+	if !ok {
+		return false
+	}
+	for _, granted := range r.Permissions {
+		if granted == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) HasRole(role string) bool {
+	_, ok := p.roles[role]
+	return ok
+}
+
+func (p *Policy) CanSelfRegister(role string) bool {
+	r, ok := p.roles[role]
+	return ok && r.SelfRegister
+}
+
+func (p *Policy) Permissions(role string) []Permission {
+	perms := append([]Permission{}, p.roles[role].Permissions...)
+	sort.Slice(perms, func(i, j int) bool { return perms[i] < perms[j] })
+	return perms
+}
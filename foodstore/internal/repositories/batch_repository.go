@@ -0,0 +1,88 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"foodstore/internal/models"
+)
+
+// stockBatchSelectSQL selects batches with their variant and the status the
+// product's expiry policy gives them today.
+const stockBatchSelectSQL = `
+	SELECT b.id, b.product_id, p.name, COALESCE(p.seller_id, 0), b.variant_id, v.sku, v.name, v.unit, b.lot_number,
+		COALESCE(to_char(b.best_before, 'YYYY-MM-DD'), ''), b.best_before - CURRENT_DATE, b.quantity,
+		CASE
+			WHEN b.best_before < CURRENT_DATE THEN 'expired'
+			WHEN NOT ` + batchSellableSQL + ` THEN 'hidden'
+			WHEN ` + batchDiscountedSQL + ` THEN 'discounted'
+			ELSE 'on_sale'
+		END,
+		b.received_at
+	FROM stock_batches b
+	JOIN product_variants v ON v.id = b.variant_id
+	JOIN products p ON p.id = b.product_id`
+
+func scanStockBatch(row rowScanner) (models.StockBatch, error) {
+	var (
+		b        models.StockBatch
+		daysLeft sql.NullInt64
+	)
+	err := row.Scan(&b.ID, &b.ProductID, &b.ProductName, &b.SellerID, &b.VariantID, &b.SKU, &b.VariantName, &b.Unit, &b.LotNumber,
+		&b.BestBefore, &daysLeft, &b.Quantity, &b.Status, &b.ReceivedAt)
+	if err != nil {
+		return b, err
+	}
+	if daysLeft.Valid {
+		days := int(daysLeft.Int64)
+		b.DaysLeft = &days
+	}
+	return b, nil
+}
+
+func (pr *ProductRepository) queryStockBatches(query string, args ...interface{}) ([]models.StockBatch, error) {
+	rows, err := pr.db.Query(stockBatchSelectSQL+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []models.StockBatch{}
+	for rows.Next() {
+		b, err := scanStockBatch(rows)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return batches, nil
+}
+
+// ListBatches returns the batches of a product that hold stock, by variant
+// and then in the order sales take them.
+func (pr *ProductRepository) ListBatches(productID int) ([]models.StockBatch, error) {
+	return pr.queryStockBatches(
+		" WHERE b.product_id = $1 AND b.quantity > 0 ORDER BY v.position, v.id, b.best_before ASC NULLS LAST, b.id",
+		productID,
+	)
+}
+
+func (pr *ProductRepository) GetBatch(id int) (*models.StockBatch, error) {
+	b, err := scanStockBatch(pr.db.QueryRow(stockBatchSelectSQL+" WHERE b.id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ExpiringBatches returns the batches with stock of sellerID's products
+// (every seller's when zero) whose best-before date is at most days away,
+// including those past it, first-expiring first.
+func (pr *ProductRepository) ExpiringBatches(sellerID, days int) ([]models.StockBatch, error) {
+	return pr.queryStockBatches(
+		" WHERE b.quantity > 0 AND b.best_before <= CURRENT_DATE + $1::integer AND ($2 = 0 OR p.seller_id = $2) ORDER BY b.best_before, p.name, b.id",
+		days, sellerID,
+	)
+}
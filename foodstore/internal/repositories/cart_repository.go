@@ -0,0 +1,230 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+type CartRepository struct {
+	db *sql.DB
+}
+
+func NewCartRepository(db *sql.DB) *CartRepository {
+	return &CartRepository{db: db}
+}
+
+func (cr *CartRepository) GetCartByUserID(userID int) (*models.Cart, error) {
+	var c models.Cart
+	err := cr.db.QueryRow(
+		"SELECT id, COALESCE(user_id, 0), updated_at FROM carts WHERE user_id = $1", userID,
+	).Scan(&c.ID, &c.UserID, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (cr *CartRepository) GetCartByTokenHash(tokenHash string) (*models.Cart, error) {
+	var c models.Cart
+	err := cr.db.QueryRow(
+		"SELECT id, COALESCE(user_id, 0), updated_at FROM carts WHERE token_hash = $1 AND user_id IS NULL", tokenHash,
+	).Scan(&c.ID, &c.UserID, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// EnsureUserCart returns the ID of the user's cart, creating it if needed.
+func (cr *CartRepository) EnsureUserCart(userID int) (int, error) {
+	var id int
+	now := time.Now()
+	err := cr.db.QueryRow(`
+		INSERT INTO carts (user_id, created_at, updated_at) VALUES ($1, $2, $2)
+		ON CONFLICT (user_id) DO UPDATE SET updated_at = carts.updated_at
+		RETURNING id
+	`, userID, now).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (cr *CartRepository) CreateAnonymousCart(tokenHash string) (int, error) {
+	var id int
+	now := time.Now()
+	err := cr.db.QueryRow(
+		"INSERT INTO carts (token_hash, created_at, updated_at) VALUES ($1, $2, $2) RETURNING id",
+		tokenHash, now,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ListItems returns the cart lines joined with the current product and
+// variant data. Price holds the variant price in ProductCurrency, and Stock
+// leaves out the holds of checkouts other than the one in session
+// sessionID (every hold when zero).
+func (cr *CartRepository) ListItems(cartID, sessionID int) ([]models.CartItem, error) {
+	rows, err := cr.db.Query(`
+		SELECT
+			ci.product_id, ci.variant_id, v.sku, v.name, COALESCE(p.seller_id, 0), p.name, COALESCE(p.image_url, ''), v.unit,
+			ci.quantity, ci.added_price, v.price, p.currency,
+			GREATEST(v.stock - COALESCE((
+				SELECT SUM(r.quantity) FROM stock_reservations r
+				WHERE r.variant_id = v.id AND r.session_id <> $2 AND r.expires_at > NOW()
+			), 0), 0),
+			ci.added_at
+		FROM cart_items ci
+		JOIN product_variants v ON v.id = ci.variant_id
+		JOIN products p ON p.id = ci.product_id
+		WHERE ci.cart_id = $1
+		ORDER BY ci.added_at ASC, ci.id ASC
+	`, cartID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.CartItem{}
+	for rows.Next() {
+		var item models.CartItem
+		if err := rows.Scan(
+			&item.ProductID, &item.VariantID, &item.SKU, &item.VariantName, &item.SellerID, &item.Name, &item.ImageURL, &item.Unit,
+			&item.Quantity, &item.AddedPrice, &item.Price, &item.ProductCurrency, &item.Stock, &item.AddedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// AddItem adds quantity of a product variant to the cart, on top of what is
+// already there. addedPrice is only recorded for new lines.
+func (cr *CartRepository) AddItem(cartID, productID, variantID int, quantity models.Quantity, addedPrice models.Money) error {
+	now := time.Now()
+	_, err := cr.db.Exec(`
+		INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, added_price, added_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (cart_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
+	`, cartID, productID, variantID, quantity, addedPrice, now)
+	if err != nil {
+		return err
+	}
+	return cr.touchCart(cartID, now)
+}
+
+func (cr *CartRepository) SetItemQuantity(cartID, variantID int, quantity models.Quantity) (bool, error) {
+	res, err := cr.db.Exec(
+		"UPDATE cart_items SET quantity = $1 WHERE cart_id = $2 AND variant_id = $3",
+		quantity, cartID, variantID,
+	)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+	return true, cr.touchCart(cartID, time.Now())
+}
+
+func (cr *CartRepository) RemoveItem(cartID, variantID int) (bool, error) {
+	res, err := cr.db.Exec("DELETE FROM cart_items WHERE cart_id = $1 AND variant_id = $2", cartID, variantID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+	return true, cr.touchCart(cartID, time.Now())
+}
+
+func (cr *CartRepository) ClearCart(cartID int) error {
+	if _, err := cr.db.Exec("DELETE FROM cart_items WHERE cart_id = $1", cartID); err != nil {
+		return err
+	}
+	return cr.touchCart(cartID, time.Now())
+}
+
+// RemoveOrderedItems takes the ordered quantities of items off the cart's
+// lines, deleting the lines that are used up. Lines added or raised after
+// the order was put together keep what was not ordered.
+func (cr *CartRepository) RemoveOrderedItems(cartID int, items []models.CartItem) error {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		_, err := tx.Exec(
+			"DELETE FROM cart_items WHERE cart_id = $1 AND variant_id = $2 AND quantity <= $3",
+			cartID, item.VariantID, item.Quantity,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		_, err = tx.Exec(
+			"UPDATE cart_items SET quantity = quantity - $1 WHERE cart_id = $2 AND variant_id = $3",
+			item.Quantity, cartID, item.VariantID,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE carts SET updated_at = $1 WHERE id = $2", time.Now(), cartID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MergeCarts moves every line of one cart into another, adding quantities
+// for variants present in both, and deletes the emptied cart.
+func (cr *CartRepository) MergeCarts(fromCartID, toCartID int) error {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO cart_items (cart_id, product_id, variant_id, quantity, added_price, added_at)
+		SELECT $2, product_id, variant_id, quantity, added_price, added_at
+		FROM cart_items
+		WHERE cart_id = $1
+		ON CONFLICT (cart_id, variant_id) DO UPDATE SET quantity = cart_items.quantity + EXCLUDED.quantity
+	`, fromCartID, toCartID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM carts WHERE id = $1", fromCartID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.Exec("UPDATE carts SET updated_at = $1 WHERE id = $2", time.Now(), toCartID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cr *CartRepository) touchCart(cartID int, at time.Time) error {
+	_, err := cr.db.Exec("UPDATE carts SET updated_at = $1 WHERE id = $2", at, cartID)
+	return err
+}
@@ -0,0 +1,250 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"foodstore/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrCategoryNotEmpty rejects deleting a category that still has
+	// products filed under it, archived ones included.
+	ErrCategoryNotEmpty = errors.New("category has products")
+	// ErrCategorySlugTaken reports a slug that another category took
+	// first.
+	ErrCategorySlugTaken = errors.New("category slug is already used")
+)
+
+type CategoryRepository struct {
+	db *sql.DB
+}
+
+func NewCategoryRepository(db *sql.DB) *CategoryRepository {
+	return &CategoryRepository{db: db}
+}
+
+// ListCategories returns every category with the number of products filed
+// directly under it that are not archived, ordered by name.
+func (cr *CategoryRepository) ListCategories() ([]models.Category, error) {
+	rows, err := cr.db.Query(`
+		SELECT c.id, COALESCE(c.parent_id, 0), c.name, c.slug, c.created_at,
+			(SELECT COUNT(*) FROM products p WHERE p.category_id = c.id AND p.archived_at IS NULL)
+		FROM categories c
+		ORDER BY LOWER(c.name), c.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []models.Category{}
+	for rows.Next() {
+		var c models.Category
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.Name, &c.Slug, &c.CreatedAt, &c.ProductCount); err != nil {
+			return nil, err
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+func (cr *CategoryRepository) GetCategoryByID(id int) (*models.Category, error) {
+	var c models.Category
+	err := cr.db.QueryRow(
+		"SELECT id, COALESCE(parent_id, 0), name, slug, created_at FROM categories WHERE id = $1", id,
+	).Scan(&c.ID, &c.ParentID, &c.Name, &c.Slug, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// FindCategory looks a category up by slug, or by name ignoring case.
+func (cr *CategoryRepository) FindCategory(name, slug string) (*models.Category, error) {
+	var c models.Category
+	err := cr.db.QueryRow(`
+		SELECT id, COALESCE(parent_id, 0), name, slug, created_at
+		FROM categories
+		WHERE slug = $2 OR LOWER(name) = LOWER($1)
+		ORDER BY (slug = $2) DESC, id
+		LIMIT 1
+	`, name, slug).Scan(&c.ID, &c.ParentID, &c.Name, &c.Slug, &c.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListUnmappedCategories returns the distinct free-text categories of
+// products that are not filed under a category yet, in order.
+func (cr *CategoryRepository) ListUnmappedCategories() ([]string, error) {
+	rows, err := cr.db.Query("SELECT DISTINCT category FROM products WHERE category_id IS NULL ORDER BY category")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// MapProducts files the products whose free-text category is category and
+// that have no category yet under the category with slug, which is created
+// with name when it does not exist.
+func (cr *CategoryRepository) MapProducts(category, name, slug string) error {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT INTO categories (name, slug, created_at) VALUES ($1, $2, $3) ON CONFLICT (slug) DO NOTHING",
+		name, slug, time.Now(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE products p
+		SET category_id = c.id, category = c.name
+		FROM categories c
+		WHERE c.slug = $1 AND p.category_id IS NULL AND p.category = $2
+	`, slug, category)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cr *CategoryRepository) SlugExists(slug string, exceptID int) (bool, error) {
+	var exists bool
+	err := cr.db.QueryRow("SELECT EXISTS(SELECT 1 FROM categories WHERE slug = $1 AND id <> $2)", slug, exceptID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (cr *CategoryRepository) CreateCategory(c models.Category) (int, error) {
+	var id int
+	err := cr.db.QueryRow(
+		"INSERT INTO categories (parent_id, name, slug, created_at) VALUES (NULLIF($1, 0), $2, $3, $4) RETURNING id",
+		c.ParentID, c.Name, c.Slug, time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, slugConflict(err)
+	}
+	return id, nil
+}
+
+// UpdateCategory renames or moves a category. Products filed under it take
+// the new name.
+func (cr *CategoryRepository) UpdateCategory(c models.Category) (bool, error) {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(
+		"UPDATE categories SET parent_id = NULLIF($1, 0), name = $2, slug = $3 WHERE id = $4",
+		c.ParentID, c.Name, c.Slug, c.ID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return false, slugConflict(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if _, err := tx.Exec("UPDATE products SET category = $1 WHERE category_id = $2", c.Name, c.ID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// slugConflict turns the unique violation of a slug taken concurrently into
+// ErrCategorySlugTaken.
+func slugConflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrCategorySlugTaken
+	}
+	return err
+}
+
+// DeleteCategory deletes a category without subcategories. Its products,
+// archived ones included, are moved to moveTo first; with moveTo zero the
+// category must have none or the delete fails with ErrCategoryNotEmpty.
+func (cr *CategoryRepository) DeleteCategory(id, moveTo int) (bool, error) {
+	tx, err := cr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	// Locking the category keeps products from being filed under it until
+	// the delete commits.
+	if _, err := tx.Exec("SELECT id FROM categories WHERE id = $1 FOR UPDATE", id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if moveTo <= 0 {
+		var filed bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE category_id = $1)", id).Scan(&filed); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+		if filed {
+			tx.Rollback()
+			return false, ErrCategoryNotEmpty
+		}
+	} else {
+		_, err := tx.Exec(`
+			UPDATE products p
+			SET category_id = c.id, category = c.name
+			FROM categories c
+			WHERE p.category_id = $1 AND c.id = $2
+		`, id, moveTo)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	res, err := tx.Exec("DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	return true, tx.Commit()
+}
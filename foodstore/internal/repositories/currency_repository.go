@@ -0,0 +1,81 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+type ExchangeRateRepository struct {
+	db *sql.DB
+}
+
+func NewExchangeRateRepository(db *sql.DB) *ExchangeRateRepository {
+	return &ExchangeRateRepository{db: db}
+}
+
+func (er *ExchangeRateRepository) ListRates() ([]models.ExchangeRate, error) {
+	rows, err := er.db.Query("SELECT currency, rate, COALESCE(updated_by, 0), updated_at FROM exchange_rates ORDER BY currency")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := []models.ExchangeRate{}
+	for rows.Next() {
+		var rate models.ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedBy, &rate.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+func (er *ExchangeRateRepository) GetRate(currency string) (*models.ExchangeRate, error) {
+	var rate models.ExchangeRate
+	err := er.db.QueryRow(
+		"SELECT currency, rate, COALESCE(updated_by, 0), updated_at FROM exchange_rates WHERE currency = $1",
+		currency,
+	).Scan(&rate.Currency, &rate.Rate, &rate.UpdatedBy, &rate.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (er *ExchangeRateRepository) UpsertRate(currency string, rate models.Rate, updatedBy int) error {
+	_, err := er.db.Exec(`
+		INSERT INTO exchange_rates (currency, rate, updated_by, updated_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4)
+		ON CONFLICT (currency) DO UPDATE
+		SET rate = EXCLUDED.rate, updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+	`, currency, rate, updatedBy, time.Now())
+	return err
+}
+
+// CurrencyInUse reports whether any product is priced in currency.
+func (er *ExchangeRateRepository) CurrencyInUse(currency string) (bool, error) {
+	var used bool
+	err := er.db.QueryRow("SELECT EXISTS(SELECT 1 FROM products WHERE currency = $1)", currency).Scan(&used)
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+func (er *ExchangeRateRepository) DeleteRate(currency string) (bool, error) {
+	res, err := er.db.Exec("DELETE FROM exchange_rates WHERE currency = $1", currency)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
@@ -0,0 +1,119 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+// ErrIdempotencyKeyTaken reports that another request placed its order with
+// the key first.
+var ErrIdempotencyKeyTaken = errors.New("idempotency key was used by another request")
+
+type IdempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// ClaimKey records a new key for the user. A key whose request is still
+// pending since before staleBefore belongs to a request that died, and is
+// taken over. It reports false when the user already used the key.
+func (ir *IdempotencyRepository) ClaimKey(userID int, key, requestHash string, staleBefore time.Time) (bool, error) {
+	res, err := ir.db.Exec(`
+		INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, idempotency_key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, created_at = EXCLUDED.created_at
+		WHERE idempotency_keys.completed_at IS NULL AND idempotency_keys.order_id IS NULL
+			AND idempotency_keys.created_at < $5
+	`, userID, key, requestHash, time.Now(), staleBefore)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+func (ir *IdempotencyRepository) GetKey(userID int, key string) (*models.IdempotencyKey, error) {
+	var k models.IdempotencyKey
+	var statusCode sql.NullInt64
+	var response sql.NullString
+	var completedAt sql.NullTime
+	err := ir.db.QueryRow(`
+		SELECT user_id, idempotency_key, request_hash, status_code, response_body, COALESCE(order_id, 0), created_at, completed_at
+		FROM idempotency_keys
+		WHERE user_id = $1 AND idempotency_key = $2
+	`, userID, key).Scan(&k.UserID, &k.Key, &k.RequestHash, &statusCode, &response, &k.OrderID, &k.CreatedAt, &completedAt)
+	if err != nil {
+		return nil, err
+	}
+	k.StatusCode = int(statusCode.Int64)
+	if response.Valid {
+		k.Response = []byte(response.String)
+	}
+	if completedAt.Valid {
+		k.CompletedAt = &completedAt.Time
+	}
+	return &k, nil
+}
+
+func (ir *IdempotencyRepository) CompleteKey(userID int, key string, statusCode int, response []byte) error {
+	_, err := ir.db.Exec(
+		"UPDATE idempotency_keys SET status_code = $1, response_body = $2, completed_at = $3 WHERE user_id = $4 AND idempotency_key = $5",
+		statusCode, string(response), time.Now(), userID, key,
+	)
+	return err
+}
+
+func (ir *IdempotencyRepository) DeleteKey(userID int, key string) error {
+	_, err := ir.db.Exec("DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2", userID, key)
+	return err
+}
+
+// DeletePendingKey removes a key whose request placed no order.
+func (ir *IdempotencyRepository) DeletePendingKey(userID int, key string) error {
+	_, err := ir.db.Exec("DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2 AND order_id IS NULL", userID, key)
+	return err
+}
+
+// completeOrderKey records in tx that the user's request with key placed
+// orderID. It does nothing for an empty key. When a request that took the
+// key over placed its order first, it fails with ErrIdempotencyKeyTaken so
+// that the order is rolled back.
+func completeOrderKey(tx *sql.Tx, userID int, key string, orderID int) error {
+	if key == "" {
+		return nil
+	}
+	res, err := tx.Exec(
+		"UPDATE idempotency_keys SET order_id = $1, completed_at = NOW() WHERE user_id = $2 AND idempotency_key = $3 AND order_id IS NULL",
+		orderID, userID, key,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrIdempotencyKeyTaken
+	}
+	return nil
+}
+
+// DeleteKeysBefore removes keys created before cutoff.
+func (ir *IdempotencyRepository) DeleteKeysBefore(cutoff time.Time) (int64, error) {
+	res, err := ir.db.Exec("DELETE FROM idempotency_keys WHERE created_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,101 @@
+package repositories
+
+import (
+	"database/sql"
+
+	"foodstore/internal/models"
+)
+
+// GetVariantBySKU returns sellerID's variant with the given SKU.
+func (pr *ProductRepository) GetVariantBySKU(sku string, sellerID int) (*models.ProductVariant, error) {
+	v, err := scanVariant(pr.db.QueryRow(variantSelectSQL+" WHERE v.sku = $1 AND COALESCE(v.seller_id, 0) = $2", sku, sellerID))
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ImportProducts applies a product import of sellerID in one transaction
+// and returns the product IDs in the order of products. Each product
+// carries one variant: a product with an ID updates that product and its
+// variant Variants[0].ID, which must be the seller's, but not its stock. A
+// product without an ID is created like CreateProduct does. An empty image
+// URL, currency or variant name keeps that of an updated product.
+func (pr *ProductRepository) ImportProducts(products []models.Product, sellerID int) ([]int, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	var updated []int
+	for _, p := range products {
+		if p.ID != 0 {
+			updated = append(updated, p.ID)
+		}
+	}
+	if err := lockProducts(tx, updated); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	ids := make([]int, len(products))
+	for i, p := range products {
+		p.SellerID = sellerID
+		if p.ID == 0 {
+			ids[i], err = insertProduct(tx, p)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			continue
+		}
+		if err := importProductUpdate(tx, p); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		ids[i] = p.ID
+	}
+	if err := refreshProductTotals(tx, updated); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// importProductUpdate updates the seller's product p.ID and its variant
+// p.Variants[0]. A product or variant that is not the seller's, or an
+// archived product, is reported as sql.ErrNoRows.
+func importProductUpdate(tx *sql.Tx, p models.Product) error {
+	res, err := tx.Exec(
+		"UPDATE products SET name = $1, description = $2, image_url = COALESCE(NULLIF($3, ''), image_url), category = $4, category_id = $5, currency = COALESCE(NULLIF($6, ''), currency) WHERE id = $7 AND seller_id = $8 AND archived_at IS NULL",
+		p.Name, p.Description, p.ImageURL, p.Category, p.CategoryID, p.Currency, p.ID, p.SellerID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	v := p.Variants[0]
+	res, err = tx.Exec(
+		"UPDATE product_variants SET price = $1, unit = $2, name = COALESCE(NULLIF($3, ''), name) WHERE id = $4 AND product_id = $5",
+		v.Price, v.Unit, v.Name, v.ID, p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	affected, err = res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
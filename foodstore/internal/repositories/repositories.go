@@ -3,115 +3,323 @@ package repositories
 import (
 	"database/sql"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"foodstore/internal/models"
+
+	"github.com/lib/pq"
 )
 
 type ProductRepository struct {
 	db *sql.DB
 }
 
-var ErrInsufficientStock = errors.New("insufficient stock")
+var (
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrStatusConflict     = errors.New("order status changed concurrently")
+	ErrProductNotArchived = errors.New("product is not archived")
+	ErrProductReferenced  = errors.New("product appears in orders")
+)
+
+// productAvailableSQL selects a product's stock on sale minus its active
+// checkout holds.
+const productAvailableSQL = "GREATEST((SELECT COALESCE(SUM(b.quantity), 0) FROM stock_batches b JOIN products p ON p.id = b.product_id WHERE b.product_id = products.id AND " + batchSellableSQL + ")" +
+	" - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r WHERE r.product_id = products.id AND r.expires_at > NOW()), 0), 0)"
 
 func NewProductRepository(db *sql.DB) *ProductRepository {
 	return &ProductRepository{db: db}
 }
 
-func (pr *ProductRepository) GetAllProducts() ([]models.Product, error) {
-	rows, err := pr.db.Query(
-		"SELECT id, COALESCE(seller_id, 0), name, description, COALESCE(image_url, ''), price, stock, category, COALESCE(unit, 'piece'), created_at FROM products ORDER BY id DESC")
+// productBasePriceSQL converts a product's price into the base currency at
+// the current rate; the base currency itself has no row in exchange_rates.
+const productBasePriceSQL = "products.price * COALESCE(er.rate, 1)"
+
+// productSortSQL maps the supported sort orders to ORDER BY clauses. Every
+// clause ends on the ID so pages are stable.
+var productSortSQL = map[string]string{
+	models.ProductSortNewest:    "products.created_at DESC, products.id DESC",
+	models.ProductSortPriceAsc:  productBasePriceSQL + " ASC, products.id DESC",
+	models.ProductSortPriceDesc: productBasePriceSQL + " DESC, products.id DESC",
+	models.ProductSortNameAsc:   "LOWER(products.name) ASC, products.id DESC",
+	models.ProductSortNameDesc:  "LOWER(products.name) DESC, products.id DESC",
+}
+
+// ListProducts returns one page of products matching q and the number of
+// matching products across all pages.
+func (pr *ProductRepository) ListProducts(q models.ProductQuery) ([]models.Product, int, error) {
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addArg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	var tsQuery string
+	if q.Search != "" {
+		// The query is parsed by both stemmers, matching either language.
+		arg := addArg(q.Search)
+		tsQuery = "(to_tsquery('english', " + arg + ") || to_tsquery('russian', " + arg + "))"
+		conditions = append(conditions, "products.search_vector @@ "+tsQuery)
+	}
+	if q.CategoryID > 0 || q.Category != "" {
+		root := "id = " + addArg(q.CategoryID)
+		if q.CategoryID <= 0 {
+			arg := addArg(q.Category)
+			root = "slug = LOWER(" + arg + ") OR LOWER(name) = LOWER(" + arg + ")"
+		}
+		conditions = append(conditions, `products.category_id IN (
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE `+root+`
+				UNION ALL
+				SELECT c.id FROM categories c JOIN subtree s ON c.parent_id = s.id
+			)
+			SELECT id FROM subtree
+		)`)
+	}
+	if q.SellerID > 0 {
+		conditions = append(conditions, "products.seller_id = "+addArg(q.SellerID))
+	}
+	if q.MinPrice != nil {
+		conditions = append(conditions, productBasePriceSQL+" >= "+addArg(*q.MinPrice))
+	}
+	if q.MaxPrice != nil {
+		conditions = append(conditions, productBasePriceSQL+" <= "+addArg(*q.MaxPrice))
+	}
+	if q.InStock {
+		conditions = append(conditions, productAvailableSQL+" > 0")
+	}
+	if q.Archived {
+		conditions = append(conditions, "products.archived_at IS NOT NULL")
+	} else {
+		conditions = append(conditions, "products.archived_at IS NULL")
+	}
+	from := " FROM products LEFT JOIN exchange_rates er ON er.currency = products.currency WHERE " + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := pr.db.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	orderBy, ok := productSortSQL[q.Sort]
+	switch {
+	case q.Sort == models.ProductSortRelevance && tsQuery != "":
+		orderBy = "ts_rank(products.search_vector, " + tsQuery + ") DESC, products.id DESC"
+	case !ok:
+		orderBy = productSortSQL[models.ProductSortNewest]
+	}
+	query := "SELECT products.id, COALESCE(products.seller_id, 0), products.name, products.description, COALESCE(products.image_url, ''), products.price, COALESCE(products.currency, ''), products.stock, " + productAvailableSQL + ", COALESCE(products.category_id, 0), products.category, COALESCE(products.unit, 'piece'), products.reorder_threshold, products.expiry_hide_days, products.expiry_discount_days, products.expiry_discount_percent, products.created_at, products.archived_at" +
+		from + " ORDER BY " + orderBy + " LIMIT " + addArg(q.Limit) + " OFFSET " + addArg(q.Offset)
+	rows, err := pr.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var products []models.Product
+	products := []models.Product{}
 	for rows.Next() {
-		var p models.Product
+		var (
+			p          models.Product
+			archivedAt sql.NullTime
+		)
 		err := rows.Scan(&p.ID, &p.SellerID, &p.Name, &p.Description, &p.ImageURL,
-			&p.Price, &p.Stock, &p.Category, &p.Unit, &p.CreatedAt)
+			&p.Price, &p.Currency, &p.Stock, &p.Available, &p.CategoryID, &p.Category, &p.Unit, &p.ReorderThreshold,
+			&p.Expiry.HideDays, &p.Expiry.DiscountDays, &p.Expiry.DiscountPercent, &p.CreatedAt, &archivedAt)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+		if archivedAt.Valid {
+			p.ArchivedAt = &archivedAt.Time
 		}
 		products = append(products, p)
 	}
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return products, nil
+	if err := pr.attachVariants(products); err != nil {
+		return nil, 0, err
+	}
+	return products, total, nil
 }
 
-func (pr *ProductRepository) GetProductsBySellerID(sellerID int) ([]models.Product, error) {
-	rows, err := pr.db.Query(
-		"SELECT id, COALESCE(seller_id, 0), name, description, COALESCE(image_url, ''), price, stock, category, COALESCE(unit, 'piece'), created_at FROM products WHERE seller_id = $1 ORDER BY id DESC",
-		sellerID,
-	)
+func (pr *ProductRepository) attachVariants(products []models.Product) error {
+	ids := make([]int, len(products))
+	for i := range products {
+		ids[i] = products[i].ID
+	}
+	variants, err := pr.ListVariants(ids)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
-
-	var products []models.Product
-	for rows.Next() {
-		var p models.Product
-		err := rows.Scan(&p.ID, &p.SellerID, &p.Name, &p.Description, &p.ImageURL,
-			&p.Price, &p.Stock, &p.Category, &p.Unit, &p.CreatedAt)
-		if err != nil {
-			return nil, err
+	for i := range products {
+		products[i].Variants = variants[products[i].ID]
+		if products[i].Variants == nil {
+			products[i].Variants = []models.ProductVariant{}
 		}
-		products = append(products, p)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, err
 	}
-	return products, nil
+	return nil
 }
 
+// CreateProduct stores the product together with its variants, p.Variants
+// in order. The product's price, stock and unit are derived from them, and
+// the variants' stock is recorded as received by the seller.
 func (pr *ProductRepository) CreateProduct(p models.Product) (int, error) {
+	if len(p.Variants) == 0 {
+		return 0, ErrLastVariant
+	}
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	id, err := insertProduct(tx, p)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertProduct is CreateProduct inside tx.
+func insertProduct(tx *sql.Tx, p models.Product) (int, error) {
+	first := p.Variants[0]
 	var id int
-	err := pr.db.QueryRow(
-		"INSERT INTO products (seller_id, name, description, image_url, price, stock, category, unit, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id",
-		p.SellerID, p.Name, p.Description, p.ImageURL, p.Price, p.Stock, p.Category, p.Unit, time.Now(),
+	err := tx.QueryRow(
+		"INSERT INTO products (seller_id, name, description, image_url, price, currency, stock, category_id, category, unit, reorder_threshold, expiry_hide_days, expiry_discount_days, expiry_discount_percent, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) RETURNING id",
+		p.SellerID, p.Name, p.Description, p.ImageURL, first.Price, p.Currency, 0, p.CategoryID, p.Category, first.Unit, p.ReorderThreshold,
+		p.Expiry.HideDays, p.Expiry.DiscountDays, p.Expiry.DiscountPercent, time.Now(),
 	).Scan(&id)
 	if err != nil {
 		return 0, err
 	}
+	for _, v := range p.Variants {
+		v.ProductID = id
+		if _, err := insertVariant(tx, v, p.SellerID); err != nil {
+			return 0, err
+		}
+	}
+	if err := refreshProductTotals(tx, []int{id}); err != nil {
+		return 0, err
+	}
 	return id, nil
 }
 
+// UpdateProduct updates a seller's product. p.Variants[0], when given,
+// updates the product's default variant p.Variants[0].ID; its SKU and
+// barcode are kept when empty. Stock is left alone: it only changes through
+// stock movements.
 func (pr *ProductRepository) UpdateProduct(p models.Product) (bool, error) {
-	res, err := pr.db.Exec(
-		"UPDATE products SET name=$1, description=$2, image_url=$3, price=$4, stock=$5, category=$6, unit=$7 WHERE id=$8 AND seller_id=$9",
-		p.Name, p.Description, p.ImageURL, p.Price, p.Stock, p.Category, p.Unit, p.ID, p.SellerID,
+	return pr.updateProduct(p, p.SellerID)
+}
+
+func (pr *ProductRepository) UpdateProductAsAdmin(p models.Product) (bool, error) {
+	return pr.updateProduct(p, 0)
+}
+
+// updateProduct updates the product p.ID; a non-zero sellerID limits the
+// update to that seller's product.
+func (pr *ProductRepository) updateProduct(p models.Product, sellerID int) (bool, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(
+		"UPDATE products SET name=$1, description=$2, image_url=$3, category=$4, currency=COALESCE(NULLIF($5, ''), currency), category_id=$6, reorder_threshold=$7, expiry_hide_days=$8, expiry_discount_days=$9, expiry_discount_percent=$10 WHERE id=$11 AND ($12 = 0 OR seller_id=$12)",
+		p.Name, p.Description, p.ImageURL, p.Category, p.Currency, p.CategoryID, p.ReorderThreshold,
+		p.Expiry.HideDays, p.Expiry.DiscountDays, p.Expiry.DiscountPercent, p.ID, sellerID,
 	)
 	if err != nil {
+		tx.Rollback()
 		return false, err
 	}
 	affected, err := res.RowsAffected()
 	if err != nil {
+		tx.Rollback()
 		return false, err
 	}
-	return affected > 0, nil
+	if affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if len(p.Variants) > 0 {
+		v := p.Variants[0]
+		_, err := tx.Exec(`
+			UPDATE product_variants
+			SET price = $1, unit = $2, sku = COALESCE(NULLIF($3, ''), sku), barcode = COALESCE(NULLIF($4, ''), barcode)
+			WHERE id = $5 AND product_id = $6
+		`, v.Price, v.Unit, v.SKU, v.Barcode, v.ID, p.ID)
+		if err != nil {
+			tx.Rollback()
+			return false, err
+		}
+		if err := refreshProductTotals(tx, []int{p.ID}); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	return true, tx.Commit()
 }
 
-func (pr *ProductRepository) UpdateProductAsAdmin(p models.Product) (bool, error) {
-	res, err := pr.db.Exec(
-		"UPDATE products SET name=$1, description=$2, image_url=$3, price=$4, stock=$5, category=$6, unit=$7 WHERE id=$8",
-		p.Name, p.Description, p.ImageURL, p.Price, p.Stock, p.Category, p.Unit, p.ID,
+// ArchiveProduct archives a seller's product, taking it off sale: cart
+// lines and checkout holds on it are dropped. Archiving an archived product
+// keeps its first archive time.
+func (pr *ProductRepository) ArchiveProduct(id int, sellerID int) (bool, error) {
+	return pr.archiveProduct(id, sellerID)
+}
+
+func (pr *ProductRepository) ArchiveProductAsAdmin(id int) (bool, error) {
+	return pr.archiveProduct(id, 0)
+}
+
+func (pr *ProductRepository) archiveProduct(id, sellerID int) (bool, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec(
+		"UPDATE products SET archived_at = COALESCE(archived_at, $1) WHERE id = $2 AND ($3 = 0 OR seller_id = $3)",
+		time.Now(), id, sellerID,
 	)
 	if err != nil {
+		tx.Rollback()
 		return false, err
 	}
 	affected, err := res.RowsAffected()
 	if err != nil {
+		tx.Rollback()
 		return false, err
 	}
-	return affected > 0, nil
+	if affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	for _, query := range []string{
+		"DELETE FROM cart_items WHERE product_id = $1",
+		"DELETE FROM stock_reservations WHERE product_id = $1",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			tx.Rollback()
+			return false, err
+		}
+	}
+	return true, tx.Commit()
 }
 
-func (pr *ProductRepository) DeleteProduct(id int, sellerID int) (bool, error) {
-	res, err := pr.db.Exec("DELETE FROM products WHERE id=$1 AND seller_id=$2", id, sellerID)
+// RestoreProduct puts a seller's archived product back on sale.
+func (pr *ProductRepository) RestoreProduct(id int, sellerID int) (bool, error) {
+	return pr.restoreProduct(id, sellerID)
+}
+
+func (pr *ProductRepository) RestoreProductAsAdmin(id int) (bool, error) {
+	return pr.restoreProduct(id, 0)
+}
+
+func (pr *ProductRepository) restoreProduct(id, sellerID int) (bool, error) {
+	res, err := pr.db.Exec("UPDATE products SET archived_at = NULL WHERE id = $1 AND ($2 = 0 OR seller_id = $2)", id, sellerID)
 	if err != nil {
 		return false, err
 	}
@@ -122,28 +330,65 @@ func (pr *ProductRepository) DeleteProduct(id int, sellerID int) (bool, error) {
 	return affected > 0, nil
 }
 
-func (pr *ProductRepository) DeleteProductAsAdmin(id int) (bool, error) {
-	res, err := pr.db.Exec("DELETE FROM products WHERE id=$1", id)
+// PurgeProduct deletes an archived product for good, together with its
+// variants and alerts; its stock ledger entries stay without the product.
+// Products that are not archived fail with ErrProductNotArchived and
+// products in orders with ErrProductReferenced.
+func (pr *ProductRepository) PurgeProduct(id int) (bool, error) {
+	tx, err := pr.db.Begin()
 	if err != nil {
 		return false, err
 	}
-	affected, err := res.RowsAffected()
+	var archivedAt sql.NullTime
+	err = tx.QueryRow("SELECT archived_at FROM products WHERE id = $1 FOR UPDATE", id).Scan(&archivedAt)
 	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
 		return false, err
 	}
-	return affected > 0, nil
+	if !archivedAt.Valid {
+		tx.Rollback()
+		return false, ErrProductNotArchived
+	}
+	var ordered bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM order_items WHERE product_id = $1)", id).Scan(&ordered); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if ordered {
+		tx.Rollback()
+		return false, ErrProductReferenced
+	}
+	if _, err := tx.Exec("DELETE FROM products WHERE id = $1", id); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
 }
 
 func (pr *ProductRepository) GetProductByID(id int) (*models.Product, error) {
 	row := pr.db.QueryRow(
-		"SELECT id, COALESCE(seller_id, 0), name, description, COALESCE(image_url, ''), price, stock, category, COALESCE(unit, 'piece'), created_at FROM products WHERE id = $1", id)
-	var p models.Product
+		"SELECT id, COALESCE(seller_id, 0), name, description, COALESCE(image_url, ''), price, COALESCE(currency, ''), stock, "+productAvailableSQL+", COALESCE(category_id, 0), category, COALESCE(unit, 'piece'), reorder_threshold, expiry_hide_days, expiry_discount_days, expiry_discount_percent, created_at, archived_at FROM products WHERE id = $1", id)
+	var (
+		p          models.Product
+		archivedAt sql.NullTime
+	)
 	err := row.Scan(&p.ID, &p.SellerID, &p.Name, &p.Description, &p.ImageURL,
-		&p.Price, &p.Stock, &p.Category, &p.Unit, &p.CreatedAt)
+		&p.Price, &p.Currency, &p.Stock, &p.Available, &p.CategoryID, &p.Category, &p.Unit, &p.ReorderThreshold,
+		&p.Expiry.HideDays, &p.Expiry.DiscountDays, &p.Expiry.DiscountPercent, &p.CreatedAt, &archivedAt)
 	if err != nil {
 		return nil, err
 	}
-	return &p, nil
+	if archivedAt.Valid {
+		p.ArchivedAt = &archivedAt.Time
+	}
+	products := []models.Product{p}
+	if err := pr.attachVariants(products); err != nil {
+		return nil, err
+	}
+	return &products[0], nil
 }
 
 type OrderRepository struct {
@@ -154,61 +399,460 @@ func NewOrderRepository(db *sql.DB) *OrderRepository {
 	return &OrderRepository{db: db}
 }
 
-func (or *OrderRepository) CreateOrder(userID int, items []models.OrderItem, total float64, deliveryAddress, phoneNumber, comment string) (int, error) {
+// ExpiryDiscount is the price in the order currency of a variant's units
+// sold from batches on expiry discount.
+type ExpiryDiscount struct {
+	Percent   int
+	UnitPrice models.Money
+}
+
+// CreateOrder stores the order and takes its items out of variant stock in
+// one transaction. Availability is checked with the variant rows locked, so
+// other checkouts' holds are respected and those of the buyer's checkout in
+// session sessionID are released. Items come priced at full price; units the sale takes from
+// batches on expiry discount are split off the first items of their variant
+// as lines priced by discounts, keyed by variant ID, and the order total is
+// the sum of the lines. A non-empty idempotencyKey, claimed by the user
+// beforehand, is marked as having placed the order in the same transaction.
+func (or *OrderRepository) CreateOrder(userID, sessionID int, items []models.OrderItem, discounts map[int]ExpiryDiscount, currency string, rate models.Rate, deliveryAddress, phoneNumber, comment, idempotencyKey string) (int, error) {
 	tx, err := or.db.Begin()
 	if err != nil {
 		return 0, err
 	}
 	var orderID int
 	err = tx.QueryRow(
-		"INSERT INTO orders (user_id, total_price, status, delivery_address, phone_number, comment, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
-		userID, total, "pending", deliveryAddress, phoneNumber, comment, time.Now(),
+		"INSERT INTO orders (user_id, total_price, currency, exchange_rate, status, delivery_address, phone_number, comment, created_at) VALUES ($1, 0, $2, $3, $4, $5, $6, $7, $8) RETURNING id",
+		userID, currency, rate, models.OrderStatusPending, deliveryAddress, phoneNumber, comment, time.Now(),
 	).Scan(&orderID)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
+
+	wanted := make(map[int]models.Quantity, len(items))
 	for _, item := range items {
-		res, err := tx.Exec(
-			"UPDATE products SET stock = stock - $1 WHERE id = $2 AND stock >= $1",
-			item.Quantity, item.ProductID,
-		)
-		if err != nil {
+		wanted[item.VariantID] += item.Quantity
+	}
+	locked, err := lockAvailableStock(tx, wanted, sessionID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	variantIDs := make([]int, 0, len(wanted))
+	productIDs := make([]int, 0, len(wanted))
+	// discounted is the quantity of each variant the sale took from batches
+	// on expiry discount.
+	discounted := make(map[int]models.Quantity, len(wanted))
+	for variantID, quantity := range wanted {
+		if locked[variantID].available < quantity {
 			tx.Rollback()
-			return 0, err
+			return 0, ErrInsufficientStock
 		}
-		affected, err := res.RowsAffected()
+		_, parts, err := applyStockParts(tx, models.StockMovement{
+			VariantID: variantID,
+			Kind:      models.StockMovementSale,
+			Quantity:  -quantity,
+			ActorID:   userID,
+			OrderID:   orderID,
+		})
 		if err != nil {
 			tx.Rollback()
 			return 0, err
 		}
-		if affected == 0 {
-			tx.Rollback()
-			return 0, ErrInsufficientStock
+		for _, part := range parts {
+			if part.discounted {
+				discounted[variantID] -= part.quantity
+			}
 		}
+		variantIDs = append(variantIDs, variantID)
+		productIDs = append(productIDs, locked[variantID].productID)
+	}
+	if err := refreshProductTotals(tx, productIDs); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	// The checkout's holds on these variants are used up by the order.
+	if _, err := tx.Exec("DELETE FROM stock_reservations WHERE session_id = $1 AND variant_id = ANY($2)", sessionID, pq.Array(variantIDs)); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 
+	var total models.Money
+	lines := make([]models.OrderItem, 0, len(items))
+	for _, item := range items {
+		if discount, ok := discounts[item.VariantID]; ok && discounted[item.VariantID] > 0 {
+			line := item
+			line.Quantity = min(discounted[item.VariantID], item.Quantity)
+			line.UnitPrice = discount.UnitPrice
+			line.DiscountPercent = discount.Percent
+			discounted[item.VariantID] -= line.Quantity
+			item.Quantity -= line.Quantity
+			lines = append(lines, line)
+		}
+		if item.Quantity > 0 {
+			lines = append(lines, item)
+		}
+	}
+	for i := range lines {
+		lines[i].LineTotal = lines[i].UnitPrice.Mul(lines[i].Quantity)
+		total += lines[i].LineTotal
+	}
+	if _, err := tx.Exec("UPDATE orders SET total_price = $1 WHERE id = $2", total, orderID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	for _, item := range lines {
 		_, err = tx.Exec(
-			"INSERT INTO order_items (order_id, product_id, quantity, unit_price, line_total) VALUES ($1, $2, $3, $4, $5)",
-			orderID, item.ProductID, item.Quantity, item.UnitPrice, item.LineTotal,
+			"INSERT INTO order_items (order_id, product_id, variant_id, variant_sku, variant_name, quantity, unit_price, discount_percent, line_total, product_name, product_unit, product_image_url) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+			orderID, item.ProductID, item.VariantID, item.SKU, item.VariantName, item.Quantity, item.UnitPrice, item.DiscountPercent, item.LineTotal, item.ProductName, item.ProductUnit, item.ProductImageURL,
 		)
 		if err != nil {
 			tx.Rollback()
 			return 0, err
 		}
 	}
+	_, err = tx.Exec(`
+		INSERT INTO order_fulfillments (order_id, seller_id, status, created_at, updated_at)
+		SELECT DISTINCT oi.order_id, p.seller_id, $2::text, $3::timestamp, $3::timestamp
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		WHERE oi.order_id = $1
+	`, orderID, models.OrderStatusPending, time.Now())
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	_, err = tx.Exec(`
+		UPDATE order_items oi
+		SET fulfillment_id = f.id
+		FROM products p, order_fulfillments f
+		WHERE oi.order_id = $1
+		  AND p.id = oi.product_id
+		  AND f.order_id = oi.order_id
+		  AND f.seller_id IS NOT DISTINCT FROM p.seller_id
+	`, orderID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := insertStatusChange(tx, orderID, 0, "", models.OrderStatusPending, userID, ""); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := completeOrderKey(tx, userID, idempotencyKey, orderID); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 	if err := tx.Commit(); err != nil {
 		return 0, err
 	}
 	return orderID, nil
 }
 
+func (or *OrderRepository) GetOrderByID(orderID int) (*models.Order, error) {
+	row := or.db.QueryRow(`
+		SELECT
+			id, user_id, total_price, currency, exchange_rate, status,
+			COALESCE(delivery_address, ''), COALESCE(phone_number, ''), COALESCE(comment, ''), created_at,
+			COALESCE(cancelled_by, 0), cancelled_at, COALESCE(cancel_reason, '')
+		FROM orders
+		WHERE id = $1
+	`, orderID)
+	var o models.Order
+	var cancelledAt sql.NullTime
+	err := row.Scan(
+		&o.ID, &o.UserID, &o.TotalPrice, &o.Currency, &o.ExchangeRate, &o.Status,
+		&o.DeliveryAddress, &o.PhoneNumber, &o.Comment, &o.CreatedAt,
+		&o.CancelledBy, &cancelledAt, &o.CancelReason,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if cancelledAt.Valid {
+		o.CancelledAt = &cancelledAt.Time
+	}
+	return &o, nil
+}
+
+// ListOrderItems returns the lines of an order. A non-zero sellerID limits
+// the result to the lines of that seller's part of the order, whoever sells
+// the product now.
+func (or *OrderRepository) ListOrderItems(orderID, sellerID int) ([]models.OrderItem, error) {
+	rows, err := or.db.Query(`
+		SELECT
+			oi.id, oi.order_id, oi.product_id, COALESCE(oi.variant_id, 0), COALESCE(oi.variant_sku, ''), COALESCE(oi.variant_name, ''),
+			COALESCE(f.seller_id, 0), oi.quantity, oi.unit_price, oi.discount_percent, oi.line_total,
+			COALESCE(oi.product_name, p.name), COALESCE(oi.product_unit, p.unit, ''), COALESCE(oi.product_image_url, p.image_url, '')
+		FROM order_items oi
+		JOIN products p ON p.id = oi.product_id
+		LEFT JOIN order_fulfillments f ON f.id = oi.fulfillment_id
+		WHERE oi.order_id = $1 AND ($2 = 0 OR f.seller_id = $2)
+		ORDER BY oi.id ASC
+	`, orderID, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.OrderItem{}
+	for rows.Next() {
+		var item models.OrderItem
+		if err := rows.Scan(
+			&item.ID, &item.OrderID, &item.ProductID, &item.VariantID, &item.SKU, &item.VariantName,
+			&item.SellerID, &item.Quantity, &item.UnitPrice, &item.DiscountPercent, &item.LineTotal,
+			&item.ProductName, &item.ProductUnit, &item.ProductImageURL,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (or *OrderRepository) OrderHasSellerItems(orderID, sellerID int) (bool, error) {
+	var exists bool
+	err := or.db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1
+			FROM order_items oi
+			JOIN order_fulfillments f ON f.id = oi.fulfillment_id
+			WHERE oi.order_id = $1 AND f.seller_id = $2
+		)
+	`, orderID, sellerID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+type FulfillmentTransition struct {
+	FulfillmentID int
+	SellerID      int
+	From          string
+	To            string
+}
+
+// ApplyFulfillmentTransitions moves seller parts of an order to new statuses
+// and updates the order-level status from orderFrom to orderTo. The order row
+// is locked for the duration, so concurrent transitions on the same order are
+// serialized; each row is only updated while it still holds the expected
+// status. Cancelled parts return their items to stock, and a cancelled order
+// records who cancelled it and why.
+func (or *OrderRepository) ApplyFulfillmentTransitions(orderID int, transitions []FulfillmentTransition, orderFrom, orderTo string, actorID int, note string) error {
+	tx, err := or.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	var locked string
+	if err := tx.QueryRow("SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&locked); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if locked != orderFrom {
+		tx.Rollback()
+		return ErrStatusConflict
+	}
+
+	now := time.Now()
+	for _, t := range transitions {
+		res, err := tx.Exec(
+			"UPDATE order_fulfillments SET status = $1, updated_at = $2 WHERE id = $3 AND order_id = $4 AND status = $5",
+			t.To, now, t.FulfillmentID, orderID, t.From,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if affected == 0 {
+			tx.Rollback()
+			return ErrStatusConflict
+		}
+		if err := insertStatusChange(tx, orderID, t.SellerID, t.From, t.To, actorID, note); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if t.To == models.OrderStatusCancelled {
+			if err := restockFulfillment(tx, t.FulfillmentID, actorID, note); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+
+	if orderTo != orderFrom {
+		if orderTo == models.OrderStatusCancelled {
+			_, err = tx.Exec(
+				"UPDATE orders SET status = $1, cancelled_by = $2, cancelled_at = $3, cancel_reason = $4 WHERE id = $5",
+				orderTo, actorID, now, note, orderID,
+			)
+		} else {
+			_, err = tx.Exec("UPDATE orders SET status = $1 WHERE id = $2", orderTo, orderID)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := insertStatusChange(tx, orderID, 0, orderFrom, orderTo, actorID, note); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// restockFulfillment returns the items of a cancelled seller part to the
+// stock of their variants as cancellation movements by actorID, into the
+// batches the sale took them from. Lines whose variant was deleted are not
+// restocked; units whose batch is gone, or sold before batches existed, go
+// to the variant's batch without lot number.
+func restockFulfillment(tx *sql.Tx, fulfillmentID, actorID int, reason string) error {
+	rows, err := tx.Query(`
+		SELECT product_id, variant_id, order_id, SUM(quantity)
+		FROM order_items
+		WHERE fulfillment_id = $1 AND variant_id IS NOT NULL
+		GROUP BY product_id, variant_id, order_id
+		ORDER BY variant_id
+	`, fulfillmentID)
+	if err != nil {
+		return err
+	}
+	var (
+		productIDs []int
+		movements  []models.StockMovement
+	)
+	for rows.Next() {
+		m := models.StockMovement{Kind: models.StockMovementCancellation, ActorID: actorID, Reason: reason}
+		if err := rows.Scan(&m.ProductID, &m.VariantID, &m.OrderID, &m.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		productIDs = append(productIDs, m.ProductID)
+		movements = append(movements, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if err := lockProducts(tx, productIDs); err != nil {
+		return err
+	}
+	for _, m := range movements {
+		parts, err := orderSaleBatches(tx, m.OrderID, m.VariantID)
+		if err != nil {
+			return err
+		}
+		for _, part := range parts {
+			if m.Quantity <= 0 {
+				break
+			}
+			back := m
+			back.BatchID = part.id
+			if back.Quantity > part.quantity {
+				back.Quantity = part.quantity
+			}
+			if _, err := applyStockMovement(tx, back); err != nil {
+				return err
+			}
+			m.Quantity -= back.Quantity
+		}
+		if _, err := applyStockMovement(tx, m); err != nil {
+			return err
+		}
+	}
+	return refreshProductTotals(tx, productIDs)
+}
+
+func (or *OrderRepository) ListFulfillments(orderIDs []int) (map[int][]models.OrderFulfillment, error) {
+	fulfillments := make(map[int][]models.OrderFulfillment, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return fulfillments, nil
+	}
+	rows, err := or.db.Query(`
+		SELECT f.id, f.order_id, COALESCE(f.seller_id, 0), COALESCE(u.name, ''), f.status, f.created_at, f.updated_at,
+			COALESCE((SELECT SUM(oi.line_total) FROM order_items oi WHERE oi.fulfillment_id = f.id), 0)
+		FROM order_fulfillments f
+		LEFT JOIN users u ON u.id = f.seller_id
+		WHERE f.order_id = ANY($1)
+		ORDER BY f.order_id, f.id
+	`, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f models.OrderFulfillment
+		if err := rows.Scan(&f.ID, &f.OrderID, &f.SellerID, &f.SellerName, &f.Status, &f.CreatedAt, &f.UpdatedAt, &f.Total); err != nil {
+			return nil, err
+		}
+		fulfillments[f.OrderID] = append(fulfillments[f.OrderID], f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return fulfillments, nil
+}
+
+// insertStatusChange records a history row. sellerID is zero for changes to
+// the order as a whole and set for changes to one seller's fulfillment.
+func insertStatusChange(tx *sql.Tx, orderID, sellerID int, from, to string, actorID int, note string) error {
+	_, err := tx.Exec(
+		"INSERT INTO order_status_history (order_id, seller_id, from_status, to_status, actor_id, note, created_at) VALUES ($1, NULLIF($2, 0), $3, $4, NULLIF($5, 0), $6, $7)",
+		orderID, sellerID, from, to, actorID, note, time.Now(),
+	)
+	return err
+}
+
+// ListStatusHistory returns the status changes of the given orders, oldest
+// first, keyed by order ID.
+func (or *OrderRepository) ListStatusHistory(orderIDs []int) (map[int][]models.OrderStatusChange, error) {
+	history := make(map[int][]models.OrderStatusChange, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return history, nil
+	}
+	rows, err := or.db.Query(`
+		SELECT h.id, h.order_id, COALESCE(h.seller_id, 0), h.from_status, h.to_status, COALESCE(h.actor_id, 0), COALESCE(u.name, ''), h.note, h.created_at
+		FROM order_status_history h
+		LEFT JOIN users u ON u.id = h.actor_id
+		WHERE h.order_id = ANY($1)
+		ORDER BY h.created_at ASC, h.id ASC
+	`, pq.Array(orderIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.OrderStatusChange
+		if err := rows.Scan(&c.ID, &c.OrderID, &c.SellerID, &c.FromStatus, &c.ToStatus, &c.ActorID, &c.ActorName, &c.Note, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		history[c.OrderID] = append(history[c.OrderID], c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
 func (or *OrderRepository) ListOrdersByUserID(userID int) ([]models.Order, error) {
 	rows, err := or.db.Query(`
 		SELECT
-			o.id, o.user_id, o.total_price, o.status,
+			o.id, o.user_id, o.total_price, o.currency, o.exchange_rate, o.status,
 			COALESCE(o.delivery_address, ''), COALESCE(o.phone_number, ''), COALESCE(o.comment, ''), o.created_at,
-			oi.id, oi.product_id, oi.quantity, oi.unit_price, oi.line_total,
-			p.name
+			COALESCE(o.cancelled_by, 0), o.cancelled_at, COALESCE(o.cancel_reason, ''),
+			oi.id, oi.product_id, oi.variant_id, oi.variant_sku, oi.variant_name, oi.quantity, oi.unit_price, COALESCE(oi.discount_percent, 0), oi.line_total,
+			COALESCE(oi.product_name, p.name)
 		FROM orders o
 		LEFT JOIN order_items oi ON oi.order_id = o.id
 		LEFT JOIN products p ON p.id = oi.product_id
@@ -225,16 +869,22 @@ func (or *OrderRepository) ListOrdersByUserID(userID int) ([]models.Order, error
 
 	for rows.Next() {
 		var o models.Order
+		var cancelledAt sql.NullTime
 		var itemID sql.NullInt64
 		var productID sql.NullInt64
-		var quantity sql.NullInt64
-		var unitPrice sql.NullFloat64
-		var lineTotal sql.NullFloat64
+		var variantID sql.NullInt64
+		var variantSKU sql.NullString
+		var variantName sql.NullString
+		var quantity models.Quantity
+		var unitPrice models.Money
+		var discountPercent int
+		var lineTotal models.Money
 		var productName sql.NullString
 
 		if err := rows.Scan(
-			&o.ID, &o.UserID, &o.TotalPrice, &o.Status, &o.DeliveryAddress, &o.PhoneNumber, &o.Comment, &o.CreatedAt,
-			&itemID, &productID, &quantity, &unitPrice, &lineTotal,
+			&o.ID, &o.UserID, &o.TotalPrice, &o.Currency, &o.ExchangeRate, &o.Status, &o.DeliveryAddress, &o.PhoneNumber, &o.Comment, &o.CreatedAt,
+			&o.CancelledBy, &cancelledAt, &o.CancelReason,
+			&itemID, &productID, &variantID, &variantSKU, &variantName, &quantity, &unitPrice, &discountPercent, &lineTotal,
 			&productName,
 		); err != nil {
 			return nil, err
@@ -242,6 +892,9 @@ func (or *OrderRepository) ListOrdersByUserID(userID int) ([]models.Order, error
 
 		existing, ok := orderMap[o.ID]
 		if !ok {
+			if cancelledAt.Valid {
+				o.CancelledAt = &cancelledAt.Time
+			}
 			o.Items = []models.OrderItem{}
 			orderMap[o.ID] = &o
 			orderIDs = append(orderIDs, o.ID)
@@ -250,13 +903,17 @@ func (or *OrderRepository) ListOrdersByUserID(userID int) ([]models.Order, error
 
 		if itemID.Valid {
 			item := models.OrderItem{
-				ID:          int(itemID.Int64),
-				OrderID:     o.ID,
-				ProductID:   int(productID.Int64),
-				Quantity:    int(quantity.Int64),
-				UnitPrice:   unitPrice.Float64,
-				LineTotal:   lineTotal.Float64,
-				ProductName: productName.String,
+				ID:              int(itemID.Int64),
+				OrderID:         o.ID,
+				ProductID:       int(productID.Int64),
+				VariantID:       int(variantID.Int64),
+				SKU:             variantSKU.String,
+				VariantName:     variantName.String,
+				Quantity:        quantity,
+				UnitPrice:       unitPrice,
+				DiscountPercent: discountPercent,
+				LineTotal:       lineTotal,
+				ProductName:     productName.String,
 			}
 			existing.Items = append(existing.Items, item)
 		}
@@ -277,14 +934,16 @@ func (or *OrderRepository) ListOrdersByUserID(userID int) ([]models.Order, error
 func (or *OrderRepository) ListOrdersForSeller(sellerID int) ([]models.SellerOrder, error) {
 	rows, err := or.db.Query(`
 		SELECT
-			o.id, o.user_id, COALESCE(u.name, ''), COALESCE(u.email, ''), o.status,
+			o.id, o.user_id, COALESCE(u.name, ''), COALESCE(u.email, ''), f.id, f.status, o.status, o.currency,
 			COALESCE(o.delivery_address, ''), COALESCE(o.phone_number, ''), COALESCE(o.comment, ''), o.created_at,
-			oi.id, oi.product_id, oi.quantity, oi.unit_price, oi.line_total, p.name
+			oi.id, oi.product_id, COALESCE(oi.variant_id, 0), COALESCE(oi.variant_sku, ''), COALESCE(oi.variant_name, ''),
+			oi.quantity, oi.unit_price, oi.discount_percent, oi.line_total, COALESCE(oi.product_name, p.name)
 		FROM orders o
 		JOIN users u ON u.id = o.user_id
-		JOIN order_items oi ON oi.order_id = o.id
+		JOIN order_fulfillments f ON f.order_id = o.id
+		JOIN order_items oi ON oi.fulfillment_id = f.id
 		JOIN products p ON p.id = oi.product_id
-		WHERE p.seller_id = $1
+		WHERE f.seller_id = $1
 		ORDER BY o.created_at DESC, o.id DESC, oi.id ASC
 	`, sellerID)
 	if err != nil {
@@ -300,9 +959,10 @@ func (or *OrderRepository) ListOrdersForSeller(sellerID int) ([]models.SellerOrd
 		var item models.OrderItem
 
 		if err := rows.Scan(
-			&o.ID, &o.UserID, &o.BuyerName, &o.BuyerEmail, &o.Status,
+			&o.ID, &o.UserID, &o.BuyerName, &o.BuyerEmail, &o.FulfillmentID, &o.Status, &o.OrderStatus, &o.Currency,
 			&o.DeliveryAddress, &o.PhoneNumber, &o.Comment, &o.CreatedAt,
-			&item.ID, &item.ProductID, &item.Quantity, &item.UnitPrice, &item.LineTotal, &item.ProductName,
+			&item.ID, &item.ProductID, &item.VariantID, &item.SKU, &item.VariantName,
+			&item.Quantity, &item.UnitPrice, &item.DiscountPercent, &item.LineTotal, &item.ProductName,
 		); err != nil {
 			return nil, err
 		}
@@ -390,6 +1050,28 @@ func (ur *UserRepository) UserExists(id int) (bool, error) {
 	return exists, nil
 }
 
+// ListRoles returns the distinct roles stored on users, in order.
+func (ur *UserRepository) ListRoles() ([]string, error) {
+	rows, err := ur.db.Query("SELECT DISTINCT role FROM users ORDER BY role")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
 func (ur *UserRepository) UserExistsByEmail(email string) (bool, error) {
 	var exists bool
 	err := ur.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", email).Scan(&exists)
@@ -434,3 +1116,8 @@ func (ur *UserRepository) GetUserByID(id int) (*models.User, error) {
 	}
 	return &u, nil
 }
+
+func (ur *UserRepository) UpdatePasswordHash(id int, passwordHash string) error {
+	_, err := ur.db.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, id)
+	return err
+}
@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+type ReservationRepository struct {
+	db *sql.DB
+}
+
+func NewReservationRepository(db *sql.DB) *ReservationRepository {
+	return &ReservationRepository{db: db}
+}
+
+// ReplaceReservations swaps the holds of the user's checkout in session
+// sessionID for the given ones, all expiring ttl from now by the database
+// clock, which every expiry check uses. Holds of the user's other sessions
+// are left alone. Each variant row is locked while its availability is
+// checked, so two buyers cannot hold the same units. Nothing is reserved
+// when any variant lacks stock.
+func (rr *ReservationRepository) ReplaceReservations(userID, sessionID int, holds []models.StockReservation, ttl time.Duration) error {
+	tx, err := rr.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM stock_reservations WHERE session_id = $1", sessionID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	wanted := make(map[int]models.Quantity, len(holds))
+	for _, h := range holds {
+		wanted[h.VariantID] += h.Quantity
+	}
+	locked, err := lockAvailableStock(tx, wanted, sessionID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for variantID, quantity := range wanted {
+		if locked[variantID].available < quantity {
+			tx.Rollback()
+			return ErrInsufficientStock
+		}
+		_, err := tx.Exec(
+			"INSERT INTO stock_reservations (user_id, session_id, product_id, variant_id, quantity, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, NOW(), NOW() + make_interval(secs => $6))",
+			userID, sessionID, locked[variantID].productID, variantID, quantity, ttl.Seconds(),
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListReservations returns the holds of the checkout in session sessionID
+// that have not expired.
+func (rr *ReservationRepository) ListReservations(sessionID int) ([]models.StockReservation, error) {
+	rows, err := rr.db.Query(`
+		SELECT id, user_id, product_id, variant_id, quantity, created_at, expires_at
+		FROM stock_reservations
+		WHERE session_id = $1 AND expires_at > NOW()
+		ORDER BY product_id, variant_id
+	`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := []models.StockReservation{}
+	for rows.Next() {
+		var h models.StockReservation
+		if err := rows.Scan(&h.ID, &h.UserID, &h.ProductID, &h.VariantID, &h.Quantity, &h.CreatedAt, &h.ExpiresAt); err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return holds, nil
+}
+
+func (rr *ReservationRepository) DeleteReservations(sessionID int) error {
+	_, err := rr.db.Exec("DELETE FROM stock_reservations WHERE session_id = $1", sessionID)
+	return err
+}
+
+// DeleteExpiredReservations removes holds that have expired.
+func (rr *ReservationRepository) DeleteExpiredReservations() (int64, error) {
+	res, err := rr.db.Exec("DELETE FROM stock_reservations WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// AvailableFor returns how much of a variant the checkout in session
+// sessionID can buy: its stock on sale minus the active holds of other
+// checkouts. A zero sessionID counts every hold.
+func (rr *ReservationRepository) AvailableFor(variantID, sessionID int) (models.Quantity, error) {
+	var available models.Quantity
+	err := rr.db.QueryRow(`
+		SELECT GREATEST(`+variantSellableSQL+` - COALESCE((
+			SELECT SUM(r.quantity) FROM stock_reservations r
+			WHERE r.variant_id = v.id AND r.session_id <> $2 AND r.expires_at > NOW()
+		), 0), 0)
+		FROM product_variants v
+		JOIN products p ON p.id = v.product_id
+		WHERE v.id = $1
+	`, variantID, sessionID).Scan(&available)
+	if err != nil {
+		return 0, err
+	}
+	return available, nil
+}
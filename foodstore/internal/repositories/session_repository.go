@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+type SessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (sr *SessionRepository) CreateSession(s models.Session) (int, error) {
+	var id int
+	err := sr.db.QueryRow(
+		"INSERT INTO sessions (user_id, token_hash, user_agent, created_at, last_seen_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id",
+		s.UserID, s.TokenHash, s.UserAgent, s.CreatedAt, s.LastSeenAt, s.ExpiresAt,
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (sr *SessionRepository) GetSessionByTokenHash(tokenHash string) (*models.Session, error) {
+	row := sr.db.QueryRow(
+		"SELECT id, user_id, token_hash, user_agent, created_at, last_seen_at, expires_at, revoked_at FROM sessions WHERE token_hash = $1",
+		tokenHash,
+	)
+	var s models.Session
+	var revokedAt sql.NullTime
+	err := row.Scan(&s.ID, &s.UserID, &s.TokenHash, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		s.RevokedAt = &revokedAt.Time
+	}
+	return &s, nil
+}
+
+func (sr *SessionRepository) TouchSession(id int, lastSeenAt time.Time) error {
+	_, err := sr.db.Exec("UPDATE sessions SET last_seen_at = $1 WHERE id = $2", lastSeenAt, id)
+	return err
+}
+
+func (sr *SessionRepository) RevokeSession(id int) error {
+	_, err := sr.db.Exec("UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", id)
+	return err
+}
+
+func (sr *SessionRepository) RevokeSessionsByUserID(userID int) (int64, error) {
+	res, err := sr.db.Exec("UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (sr *SessionRepository) DeleteExpiredSessions(idleBefore time.Time) (int64, error) {
+	res, err := sr.db.Exec(
+		"DELETE FROM sessions WHERE expires_at < NOW() OR last_seen_at < $1 OR revoked_at IS NOT NULL",
+		idleBefore,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
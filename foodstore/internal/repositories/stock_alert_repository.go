@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+type StockAlertRepository struct {
+	db *sql.DB
+}
+
+func NewStockAlertRepository(db *sql.DB) *StockAlertRepository {
+	return &StockAlertRepository{db: db}
+}
+
+// ScanStockLevels resolves the open alerts whose variant no longer matches
+// them or whose product was archived, and opens an alert for every variant
+// at or below its product's reorder threshold (low_stock) or without stock
+// (out_of_stock) that has no open alert of that kind yet. Stock is the
+// sellable stock, so hidden and expired batches do not count. Archived
+// products raise no alerts.
+func (ar *StockAlertRepository) ScanStockLevels(now time.Time) (opened, resolved int64, err error) {
+	tx, err := ar.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := tx.Exec(`
+		UPDATE stock_alerts a
+		SET resolved_at = $1
+		FROM product_variants v
+		JOIN products p ON p.id = v.product_id
+		WHERE a.resolved_at IS NULL AND v.id = a.variant_id
+			AND (p.archived_at IS NOT NULL OR NOT CASE a.kind
+				WHEN 'out_of_stock' THEN `+variantSellableSQL+` <= 0
+				ELSE `+variantSellableSQL+` > 0 AND `+variantSellableSQL+` <= p.reorder_threshold
+			END)
+	`, now)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if resolved, err = res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	res, err = tx.Exec(`
+		INSERT INTO stock_alerts (seller_id, product_id, variant_id, kind, stock, threshold, created_at)
+		SELECT s.seller_id, s.product_id, s.variant_id, CASE WHEN s.stock <= 0 THEN 'out_of_stock' ELSE 'low_stock' END, s.stock, s.reorder_threshold, $1
+		FROM (
+			SELECT p.seller_id, p.id AS product_id, v.id AS variant_id, p.reorder_threshold, `+variantSellableSQL+` AS stock
+			FROM product_variants v
+			JOIN products p ON p.id = v.product_id
+			WHERE p.archived_at IS NULL
+		) s
+		WHERE s.stock <= 0 OR s.stock <= s.reorder_threshold
+		ON CONFLICT (variant_id, kind) WHERE resolved_at IS NULL DO NOTHING
+	`, now)
+	if err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	if opened, err = res.RowsAffected(); err != nil {
+		tx.Rollback()
+		return 0, 0, err
+	}
+	return opened, resolved, tx.Commit()
+}
+
+// ListAlerts returns one page of alerts, newest first, of sellerID's
+// products (every seller when zero), only the open ones when openOnly is
+// set. It also returns the number of matching alerts and of open alerts not
+// yet read.
+func (ar *StockAlertRepository) ListAlerts(sellerID int, openOnly bool, limit, offset int) ([]models.StockAlert, int, int, error) {
+	var total, unread int
+	err := ar.db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE NOT $2 OR resolved_at IS NULL),
+			COUNT(*) FILTER (WHERE resolved_at IS NULL AND read_at IS NULL)
+		FROM stock_alerts
+		WHERE $1 = 0 OR seller_id = $1
+	`, sellerID, openOnly).Scan(&total, &unread)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	rows, err := ar.db.Query(`
+		SELECT a.id, COALESCE(a.seller_id, 0), a.product_id, p.name, a.variant_id, v.sku, v.name, v.unit,
+			a.kind, a.stock, a.threshold, `+variantSellableSQL+`, a.created_at, a.read_at, a.resolved_at
+		FROM stock_alerts a
+		JOIN products p ON p.id = a.product_id
+		JOIN product_variants v ON v.id = a.variant_id
+		WHERE ($1 = 0 OR a.seller_id = $1) AND (NOT $2 OR a.resolved_at IS NULL)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $3 OFFSET $4
+	`, sellerID, openOnly, limit, offset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	alerts := []models.StockAlert{}
+	for rows.Next() {
+		var (
+			a                  models.StockAlert
+			readAt, resolvedAt sql.NullTime
+		)
+		err := rows.Scan(&a.ID, &a.SellerID, &a.ProductID, &a.ProductName, &a.VariantID, &a.SKU, &a.VariantName, &a.Unit,
+			&a.Kind, &a.Stock, &a.Threshold, &a.CurrentStock, &a.CreatedAt, &readAt, &resolvedAt)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if readAt.Valid {
+			a.ReadAt = &readAt.Time
+		}
+		if resolvedAt.Valid {
+			a.ResolvedAt = &resolvedAt.Time
+		}
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	return alerts, total, unread, nil
+}
+
+// MarkAlertRead marks alert id read if it belongs to sellerID and reports
+// whether it does. Marking a read alert again keeps its first read time.
+func (ar *StockAlertRepository) MarkAlertRead(id, sellerID int) (bool, error) {
+	res, err := ar.db.Exec(
+		"UPDATE stock_alerts SET read_at = COALESCE(read_at, $1) WHERE id = $2 AND seller_id = $3",
+		time.Now(), id, sellerID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// MarkAllAlertsRead marks every unread alert of sellerID read and returns
+// how many there were.
+func (ar *StockAlertRepository) MarkAllAlertsRead(sellerID int) (int64, error) {
+	res, err := ar.db.Exec(
+		"UPDATE stock_alerts SET read_at = $1 WHERE read_at IS NULL AND seller_id = $2",
+		time.Now(), sellerID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
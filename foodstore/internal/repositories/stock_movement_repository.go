@@ -0,0 +1,362 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"foodstore/internal/models"
+)
+
+// ErrLotMismatch rejects a receipt into an existing lot under a different
+// best-before date.
+var ErrLotMismatch = errors.New("lot exists with another best-before date")
+
+const stockMovementSelectSQL = `
+	SELECT m.id, COALESCE(m.product_id, 0), COALESCE(m.variant_id, 0), m.variant_sku, COALESCE(m.batch_id, 0), m.lot_number,
+		COALESCE(to_char(b.best_before, 'YYYY-MM-DD'), ''), m.kind, m.quantity, m.stock_after,
+		COALESCE(m.actor_id, 0), COALESCE(u.name, ''), COALESCE(m.order_id, 0), m.reason, m.created_at
+	FROM stock_movements m
+	LEFT JOIN stock_batches b ON b.id = m.batch_id
+	LEFT JOIN users u ON u.id = m.actor_id`
+
+func scanStockMovement(row rowScanner) (models.StockMovement, error) {
+	var m models.StockMovement
+	err := row.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.SKU, &m.BatchID, &m.LotNumber,
+		&m.BestBefore, &m.Kind, &m.Quantity, &m.StockAfter,
+		&m.ActorID, &m.ActorName, &m.OrderID, &m.Reason, &m.CreatedAt)
+	return m, err
+}
+
+// applyStockMovement changes the stock of m.VariantID by m.Quantity and
+// appends the change to the ledger. It is the only writer of variant and
+// batch stock: callers hold the product lock and refresh the product totals
+// afterwards. A non-zero m.ProductID must own the variant and a non-zero
+// m.BatchID must be one of its batches. Without a batch, added stock goes to
+// the variant's batch without lot number and removed stock is taken from
+// the first-expiring batches (for sales only from those still on sale),
+// one ledger entry per batch. A movement that would take stock below zero
+// fails with ErrInsufficientStock; a zero movement is not recorded. It
+// returns the ID of the last entry.
+func applyStockMovement(tx *sql.Tx, m models.StockMovement) (int, error) {
+	id, _, err := applyStockParts(tx, m)
+	return id, err
+}
+
+// applyStockParts is applyStockMovement that also returns the share of the
+// movement each batch took.
+func applyStockParts(tx *sql.Tx, m models.StockMovement) (int, []batchPart, error) {
+	if m.Quantity == 0 {
+		return 0, nil, nil
+	}
+	var (
+		productID int
+		sku       string
+		stock     models.Quantity
+	)
+	err := tx.QueryRow("SELECT product_id, sku, stock FROM product_variants WHERE id = $1 FOR UPDATE", m.VariantID).Scan(&productID, &sku, &stock)
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.ProductID != 0 && m.ProductID != productID {
+		return 0, nil, sql.ErrNoRows
+	}
+	if stock+m.Quantity < 0 {
+		return 0, nil, ErrInsufficientStock
+	}
+	parts, err := allocateBatches(tx, productID, m)
+	if err != nil {
+		return 0, nil, err
+	}
+	var id int
+	now := time.Now()
+	for _, part := range parts {
+		stock += part.quantity
+		if _, err := tx.Exec("UPDATE stock_batches SET quantity = quantity + $1 WHERE id = $2", part.quantity, part.id); err != nil {
+			return 0, nil, err
+		}
+		err = tx.QueryRow(
+			"INSERT INTO stock_movements (product_id, variant_id, variant_sku, batch_id, lot_number, kind, quantity, stock_after, actor_id, order_id, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), NULLIF($10, 0), $11, $12) RETURNING id",
+			productID, m.VariantID, sku, part.id, part.lotNumber, m.Kind, part.quantity, stock, m.ActorID, m.OrderID, m.Reason, now,
+		).Scan(&id)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	if _, err := tx.Exec("UPDATE product_variants SET stock = $1 WHERE id = $2", stock, m.VariantID); err != nil {
+		return 0, nil, err
+	}
+	return id, parts, nil
+}
+
+// batchPart is the share of a stock movement that falls on one batch.
+// discounted tells whether stock taken from the batch was on expiry
+// discount.
+type batchPart struct {
+	id         int
+	lotNumber  string
+	quantity   models.Quantity
+	discounted bool
+}
+
+// allocateBatches splits m over the batches of its variant as described at
+// applyStockMovement, locking the batches it takes stock from.
+func allocateBatches(tx *sql.Tx, productID int, m models.StockMovement) ([]batchPart, error) {
+	if m.BatchID != 0 {
+		part := batchPart{id: m.BatchID, quantity: m.Quantity}
+		var onHand models.Quantity
+		err := tx.QueryRow(
+			"SELECT lot_number, quantity FROM stock_batches WHERE id = $1 AND variant_id = $2 FOR UPDATE",
+			m.BatchID, m.VariantID,
+		).Scan(&part.lotNumber, &onHand)
+		if err != nil {
+			return nil, err
+		}
+		if onHand+m.Quantity < 0 {
+			return nil, ErrInsufficientStock
+		}
+		return []batchPart{part}, nil
+	}
+	if m.Quantity > 0 {
+		id, err := lotBatch(tx, productID, m.VariantID, "", "")
+		if err != nil {
+			return nil, err
+		}
+		return []batchPart{{id: id, quantity: m.Quantity}}, nil
+	}
+
+	query := "SELECT b.id, b.lot_number, b.quantity, COALESCE(" + batchDiscountedSQL + ", false) FROM stock_batches b JOIN products p ON p.id = b.product_id WHERE b.variant_id = $1 AND b.quantity > 0"
+	if m.Kind == models.StockMovementSale {
+		query += " AND " + batchSellableSQL
+	}
+	rows, err := tx.Query(query+" ORDER BY b.best_before ASC NULLS LAST, b.id FOR UPDATE OF b", m.VariantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	need := -m.Quantity
+	parts := []batchPart{}
+	for rows.Next() {
+		var (
+			part   batchPart
+			onHand models.Quantity
+		)
+		if err := rows.Scan(&part.id, &part.lotNumber, &onHand, &part.discounted); err != nil {
+			return nil, err
+		}
+		take := onHand
+		if take > need {
+			take = need
+		}
+		part.quantity = -take
+		parts = append(parts, part)
+		need -= take
+		if need == 0 {
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if need > 0 {
+		return nil, ErrInsufficientStock
+	}
+	return parts, nil
+}
+
+// lotBatch returns the ID of the variant's batch lotNumber, creating it
+// with bestBefore (2006-01-02, empty for none) when missing. Given a
+// bestBefore, an existing lot must carry the same date, else
+// ErrLotMismatch.
+func lotBatch(tx *sql.Tx, productID, variantID int, lotNumber, bestBefore string) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		INSERT INTO stock_batches (product_id, variant_id, lot_number, best_before, received_at)
+		VALUES ($1, $2, $3, NULLIF($4, '')::date, $5)
+		ON CONFLICT (variant_id, lot_number) DO NOTHING
+		RETURNING id
+	`, productID, variantID, lotNumber, bestBefore, time.Now()).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	var current string
+	err = tx.QueryRow(
+		"SELECT id, COALESCE(to_char(best_before, 'YYYY-MM-DD'), '') FROM stock_batches WHERE variant_id = $1 AND lot_number = $2",
+		variantID, lotNumber,
+	).Scan(&id, &current)
+	if err != nil {
+		return 0, err
+	}
+	if bestBefore != "" && bestBefore != current {
+		return 0, ErrLotMismatch
+	}
+	return id, nil
+}
+
+// orderSaleBatches returns the batches a variant's sale for an order was
+// taken from, with the quantity taken from each.
+func orderSaleBatches(tx *sql.Tx, orderID, variantID int) ([]batchPart, error) {
+	rows, err := tx.Query(`
+		SELECT batch_id, lot_number, -SUM(quantity)
+		FROM stock_movements
+		WHERE order_id = $1 AND variant_id = $2 AND kind = 'sale' AND batch_id IS NOT NULL
+		GROUP BY batch_id, lot_number
+		ORDER BY batch_id
+	`, orderID, variantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parts := []batchPart{}
+	for rows.Next() {
+		var part batchPart
+		if err := rows.Scan(&part.id, &part.lotNumber, &part.quantity); err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// setVariantStock records the adjustment that brings a variant's stock to
+// target.
+func setVariantStock(tx *sql.Tx, variantID int, target models.Quantity, actorID int, reason string) error {
+	var stock models.Quantity
+	if err := tx.QueryRow("SELECT stock FROM product_variants WHERE id = $1 FOR UPDATE", variantID).Scan(&stock); err != nil {
+		return err
+	}
+	_, err := applyStockMovement(tx, models.StockMovement{
+		VariantID: variantID,
+		Kind:      models.StockMovementAdjustment,
+		Quantity:  target - stock,
+		ActorID:   actorID,
+		Reason:    reason,
+	})
+	return err
+}
+
+// RecordStockMovement applies a movement to a variant of m.ProductID and
+// returns the ID of its last ledger entry. A receipt with m.LotNumber goes
+// into that lot, which is created with m.BestBefore when new.
+func (pr *ProductRepository) RecordStockMovement(m models.StockMovement) (int, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if err := lockProducts(tx, []int{m.ProductID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if m.BatchID == 0 && m.LotNumber != "" {
+		var owner int
+		if err := tx.QueryRow("SELECT product_id FROM product_variants WHERE id = $1", m.VariantID).Scan(&owner); err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+		if owner != m.ProductID {
+			tx.Rollback()
+			return 0, sql.ErrNoRows
+		}
+		m.BatchID, err = lotBatch(tx, m.ProductID, m.VariantID, m.LotNumber, m.BestBefore)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	id, err := applyStockMovement(tx, m)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := refreshProductTotals(tx, []int{m.ProductID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+func (pr *ProductRepository) GetStockMovement(id int) (*models.StockMovement, error) {
+	m, err := scanStockMovement(pr.db.QueryRow(stockMovementSelectSQL+" WHERE m.id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ListStockMovements returns one page of the movements matching q, newest
+// first, together with the number of matching movements and their
+// quantities added up by kind.
+func (pr *ProductRepository) ListStockMovements(q models.StockMovementQuery) ([]models.StockMovement, int, map[string]models.Quantity, error) {
+	args := []interface{}{q.ProductID}
+	addArg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	conditions := []string{"m.product_id = $1"}
+	if q.VariantID > 0 {
+		conditions = append(conditions, "m.variant_id = "+addArg(q.VariantID))
+	}
+	if q.Kind != "" {
+		conditions = append(conditions, "m.kind = "+addArg(q.Kind))
+	}
+	if q.From != nil {
+		conditions = append(conditions, "m.created_at >= "+addArg(*q.From))
+	}
+	if q.To != nil {
+		conditions = append(conditions, "m.created_at < "+addArg(*q.To))
+	}
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	rows, err := pr.db.Query("SELECT m.kind, COUNT(*), SUM(m.quantity) FROM stock_movements m"+where+" GROUP BY m.kind", args...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	total := 0
+	totals := map[string]models.Quantity{}
+	for rows.Next() {
+		var (
+			kind  string
+			count int
+			sum   models.Quantity
+		)
+		if err := rows.Scan(&kind, &count, &sum); err != nil {
+			rows.Close()
+			return nil, 0, nil, err
+		}
+		total += count
+		totals[kind] = sum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, nil, err
+	}
+
+	rows, err = pr.db.Query(stockMovementSelectSQL+where+" ORDER BY m.created_at DESC, m.id DESC LIMIT "+addArg(q.Limit)+" OFFSET "+addArg(q.Offset), args...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rows.Close()
+
+	movements := []models.StockMovement{}
+	for rows.Next() {
+		m, err := scanStockMovement(rows)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, nil, err
+	}
+	return movements, total, totals, nil
+}
@@ -0,0 +1,367 @@
+package repositories
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+
+	"foodstore/internal/models"
+
+	"github.com/lib/pq"
+)
+
+var ErrLastVariant = errors.New("a product needs at least one variant")
+
+// batchSellableSQL holds for a batch b of product p that is still on sale:
+// the product is not archived and the batch has no best-before date or the
+// date is at least expiry_hide_days away.
+const batchSellableSQL = "(p.archived_at IS NULL AND (b.best_before IS NULL OR b.best_before >= CURRENT_DATE + p.expiry_hide_days))"
+
+// batchDiscountedSQL holds for a batch b of product p within the discount
+// window before its best-before date.
+const batchDiscountedSQL = "(p.expiry_discount_percent > 0 AND b.best_before < CURRENT_DATE + p.expiry_discount_days)"
+
+// variantSellableSQL selects the stock of variant v's batches on sale; p is
+// the variant's product.
+const variantSellableSQL = "(SELECT COALESCE(SUM(b.quantity), 0) FROM stock_batches b WHERE b.variant_id = v.id AND " + batchSellableSQL + ")"
+
+// variantAvailableSQL selects a variant's stock on sale minus its active
+// checkout holds.
+const variantAvailableSQL = "GREATEST(" + variantSellableSQL + " - COALESCE((SELECT SUM(r.quantity) FROM stock_reservations r WHERE r.variant_id = v.id AND r.expires_at > NOW()), 0), 0)"
+
+// variantDiscountedSQL selects the stock of variant v's batches on sale at
+// the expiry discount.
+const variantDiscountedSQL = "(SELECT COALESCE(SUM(b.quantity), 0) FROM stock_batches b WHERE b.variant_id = v.id AND " + batchSellableSQL + " AND " + batchDiscountedSQL + ")"
+
+const variantSelectSQL = "SELECT v.id, v.product_id, v.sku, v.name, v.price, v.stock, " + variantAvailableSQL + ", v.unit, COALESCE(v.barcode, ''), v.position, v.created_at, " +
+	variantDiscountedSQL + ", p.expiry_discount_percent FROM product_variants v JOIN products p ON p.id = v.product_id"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVariant reads a row of variantSelectSQL. Discounted stock that is
+// held by checkouts is not offered, so it is capped at the available stock.
+func scanVariant(row rowScanner) (models.ProductVariant, error) {
+	var (
+		v       models.ProductVariant
+		percent int
+	)
+	err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &v.Name, &v.Price, &v.Stock, &v.Available, &v.Unit, &v.Barcode, &v.Position, &v.CreatedAt,
+		&v.Discounted, &percent)
+	if err != nil {
+		return v, err
+	}
+	if v.Discounted > v.Available {
+		v.Discounted = v.Available
+	}
+	if v.Discounted > 0 {
+		price := v.Price.PercentOff(percent)
+		v.DiscountPercent = percent
+		v.DiscountPrice = &price
+	}
+	return v, nil
+}
+
+// ListVariants returns the variants of the given products by product ID,
+// each list in position order.
+func (pr *ProductRepository) ListVariants(productIDs []int) (map[int][]models.ProductVariant, error) {
+	variants := make(map[int][]models.ProductVariant, len(productIDs))
+	if len(productIDs) == 0 {
+		return variants, nil
+	}
+	rows, err := pr.db.Query(variantSelectSQL+" WHERE v.product_id = ANY($1) ORDER BY v.product_id, v.position, v.id", pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants[v.ProductID] = append(variants[v.ProductID], v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (pr *ProductRepository) GetVariant(id int) (*models.ProductVariant, error) {
+	v, err := scanVariant(pr.db.QueryRow(variantSelectSQL+" WHERE v.id = $1", id))
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// VariantSKUExists reports whether another variant of sellerID than
+// exceptID uses sku.
+func (pr *ProductRepository) VariantSKUExists(sku string, sellerID, exceptID int) (bool, error) {
+	var exists bool
+	err := pr.db.QueryRow("SELECT EXISTS(SELECT 1 FROM product_variants WHERE sku = $1 AND COALESCE(seller_id, 0) = $2 AND id <> $3)", sku, sellerID, exceptID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// VariantBarcodeExists reports whether another variant than exceptID uses
+// barcode.
+func (pr *ProductRepository) VariantBarcodeExists(barcode string, exceptID int) (bool, error) {
+	var exists bool
+	err := pr.db.QueryRow("SELECT EXISTS(SELECT 1 FROM product_variants WHERE barcode = $1 AND id <> $2)", barcode, exceptID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// CreateVariant adds a variant after the product's existing ones; its stock
+// is recorded as a receipt by actorID.
+func (pr *ProductRepository) CreateVariant(v models.ProductVariant, actorID int) (int, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	if err := lockProducts(tx, []int{v.ProductID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	id, err := insertVariant(tx, v, actorID)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := refreshProductTotals(tx, []int{v.ProductID}); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// UpdateVariant updates a variant, but not its stock, which only changes
+// through stock movements.
+func (pr *ProductRepository) UpdateVariant(v models.ProductVariant) (bool, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	if err := lockProducts(tx, []int{v.ProductID}); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	res, err := tx.Exec(
+		"UPDATE product_variants SET sku = $1, name = $2, price = $3, unit = $4, barcode = NULLIF($5, '') WHERE id = $6 AND product_id = $7",
+		v.SKU, v.Name, v.Price, v.Unit, v.Barcode, v.ID, v.ProductID,
+	)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if affected == 0 {
+		tx.Rollback()
+		return false, nil
+	}
+	if err := refreshProductTotals(tx, []int{v.ProductID}); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// DeleteVariant removes a variant unless it is the product's last one. Cart
+// lines and holds on it go with it; order lines keep their snapshot. Its
+// remaining stock is first written off as an adjustment by actorID, so the
+// ledger still adds up.
+func (pr *ProductRepository) DeleteVariant(productID, id, actorID int) (bool, error) {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	if err := lockProducts(tx, []int{productID}); err != nil {
+		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM product_variants WHERE product_id = $1", productID).Scan(&count); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM product_variants WHERE id = $1 AND product_id = $2)", id, productID).Scan(&exists); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if !exists {
+		tx.Rollback()
+		return false, nil
+	}
+	if count <= 1 {
+		tx.Rollback()
+		return false, ErrLastVariant
+	}
+	if err := setVariantStock(tx, id, 0, actorID, "variant deleted"); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if _, err := tx.Exec("DELETE FROM product_variants WHERE id = $1 AND product_id = $2", id, productID); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	if err := refreshProductTotals(tx, []int{productID}); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// insertVariant appends v to its product. Without a SKU the variant gets
+// "P<product id>-<variant id>". The variant starts empty and v.Stock is
+// received by actorID.
+func insertVariant(tx *sql.Tx, v models.ProductVariant, actorID int) (int, error) {
+	var id int
+	err := tx.QueryRow(`
+		WITH next AS (SELECT nextval(pg_get_serial_sequence('product_variants', 'id')) AS id)
+		INSERT INTO product_variants (id, product_id, seller_id, sku, name, price, stock, unit, barcode, position, created_at)
+		SELECT next.id, $1::integer, (SELECT seller_id FROM products WHERE id = $1::integer), COALESCE(NULLIF($2, ''), 'P' || $1::integer || '-' || next.id), $3, $4, 0, $5, NULLIF($6, ''),
+			COALESCE((SELECT MAX(position) + 1 FROM product_variants WHERE product_id = $1::integer), 0), $7
+		FROM next
+		RETURNING id
+	`, v.ProductID, v.SKU, v.Name, v.Price, v.Unit, v.Barcode, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	_, err = applyStockMovement(tx, models.StockMovement{
+		VariantID: id,
+		Kind:      models.StockMovementReceipt,
+		Quantity:  v.Stock,
+		ActorID:   actorID,
+		Reason:    "initial stock",
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// refreshProductTotals recomputes the listing summary of products from their
+// variants: the lowest price, the total stock and the first variant's unit.
+func refreshProductTotals(tx *sql.Tx, productIDs []int) error {
+	_, err := tx.Exec(`
+		UPDATE products p
+		SET price = t.price, stock = t.stock, unit = t.unit
+		FROM (
+			SELECT DISTINCT ON (product_id)
+				product_id, MIN(price) OVER w AS price, SUM(stock) OVER w AS stock, unit
+			FROM product_variants
+			WHERE product_id = ANY($1)
+			WINDOW w AS (PARTITION BY product_id)
+			ORDER BY product_id, position, id
+		) t
+		WHERE p.id = t.product_id
+	`, pq.Array(productIDs))
+	return err
+}
+
+// lockProducts locks product rows in ID order. Every transaction that
+// changes variant stock locks the products first, so they cannot deadlock
+// with one another.
+func lockProducts(tx *sql.Tx, productIDs []int) error {
+	ids := append([]int(nil), productIDs...)
+	sort.Ints(ids)
+	for i, id := range ids {
+		if i > 0 && id == ids[i-1] {
+			continue
+		}
+		var locked int
+		if err := tx.QueryRow("SELECT id FROM products WHERE id = $1 FOR UPDATE", id).Scan(&locked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lockedVariant is a variant locked by lockAvailableStock.
+type lockedVariant struct {
+	productID int
+	available models.Quantity
+}
+
+// lockAvailableStock locks the variants' products and then the variant rows,
+// both in ID order, and returns per variant its product and the stock on
+// sale minus the active holds of checkouts other than the one in session
+// sessionID. Holds are summed after the lock is taken, so a hold committed
+// while waiting is seen.
+func lockAvailableStock(tx *sql.Tx, variants map[int]models.Quantity, sessionID int) (map[int]lockedVariant, error) {
+	ids := make([]int, 0, len(variants))
+	for id := range variants {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	productIDs, err := variantProductIDs(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockProducts(tx, productIDs); err != nil {
+		return nil, err
+	}
+
+	locked := make(map[int]lockedVariant, len(ids))
+	for _, id := range ids {
+		var (
+			productID int
+			stock     models.Quantity
+		)
+		err := tx.QueryRow(
+			"SELECT v.product_id, "+variantSellableSQL+" FROM product_variants v JOIN products p ON p.id = v.product_id WHERE v.id = $1 FOR UPDATE OF v",
+			id,
+		).Scan(&productID, &stock)
+		if err != nil {
+			return nil, err
+		}
+		var held models.Quantity
+		err = tx.QueryRow(
+			"SELECT COALESCE(SUM(quantity), 0) FROM stock_reservations WHERE variant_id = $1 AND session_id <> $2 AND expires_at > NOW()",
+			id, sessionID,
+		).Scan(&held)
+		if err != nil {
+			return nil, err
+		}
+		locked[id] = lockedVariant{productID: productID, available: stock - held}
+	}
+	return locked, nil
+}
+
+func variantProductIDs(tx *sql.Tx, variantIDs []int) ([]int, error) {
+	rows, err := tx.Query("SELECT DISTINCT product_id FROM product_variants WHERE id = ANY($1)", pq.Array(variantIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	productIDs := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		productIDs = append(productIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return productIDs, nil
+}
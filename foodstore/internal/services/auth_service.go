@@ -0,0 +1,152 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+const (
+	defaultSessionTTL     = 24 * time.Hour
+	defaultSessionIdleTTL = 2 * time.Hour
+	sessionTouchInterval  = time.Minute
+)
+
+// AuthService issues session tokens of the form "<session key>.<signature>".
+// The signature lets forged tokens be rejected without a database lookup;
+// the session itself lives in the sessions table, keyed by a SHA-256 of the
+// session key, so it can be expired or revoked server-side.
+type AuthService struct {
+	sessionRepo *repositories.SessionRepository
+	secret      []byte
+	ttl         time.Duration
+	idleTTL     time.Duration
+}
+
+func NewAuthService(sr *repositories.SessionRepository, secret string, ttl, idleTTL time.Duration) (*AuthService, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	if ttl <= 0 {
+		ttl = defaultSessionTTL
+	}
+	if idleTTL <= 0 {
+		idleTTL = defaultSessionIdleTTL
+	}
+	return &AuthService{sessionRepo: sr, secret: key, ttl: ttl, idleTTL: idleTTL}, nil
+}
+
+func (as *AuthService) CreateSession(userID int, userAgent string) (string, time.Time, error) {
+	sessionKey, err := randomToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		TokenHash:  hashToken(sessionKey),
+		UserAgent:  userAgent,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(as.ttl),
+	}
+	if _, err := as.sessionRepo.CreateSession(session); err != nil {
+		return "", time.Time{}, err
+	}
+	return sessionKey + "." + as.sign(sessionKey), session.ExpiresAt, nil
+}
+
+func (as *AuthService) Authenticate(token string) (*models.Session, error) {
+	sessionKey, signature, ok := strings.Cut(token, ".")
+	if !ok || sessionKey == "" {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(signature), []byte(as.sign(sessionKey))) {
+		return nil, ErrInvalidToken
+	}
+
+	session, err := as.sessionRepo.GetSessionByTokenHash(hashToken(sessionKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || !now.Before(session.ExpiresAt) || now.Sub(session.LastSeenAt) >= as.idleTTL {
+		return nil, ErrInvalidToken
+	}
+	if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+		if err := as.sessionRepo.TouchSession(session.ID, now); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = now
+	}
+	return session, nil
+}
+
+func (as *AuthService) RevokeSession(sessionID int) error {
+	return as.sessionRepo.RevokeSession(sessionID)
+}
+
+func (as *AuthService) RevokeUserSessions(userID int) (int64, error) {
+	if userID <= 0 {
+		return 0, ErrUserNotFound
+	}
+	return as.sessionRepo.RevokeSessionsByUserID(userID)
+}
+
+func (as *AuthService) StartSessionCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := as.sessionRepo.DeleteExpiredSessions(time.Now().Add(-as.idleTTL))
+			if err != nil {
+				log.Printf("Background: session cleanup failed: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Background: removed %d expired sessions", removed)
+			}
+		}
+	}()
+}
+
+func (as *AuthService) sign(payload string) string {
+	mac := hmac.New(sha256.New, as.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// hashToken is how bearer secrets (session keys, cart tokens) are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"errors"
+
+	"foodstore/internal/models"
+)
+
+const (
+	defaultExpiryReportDays = 7
+	maxExpiryDays           = 365
+	maxExpiryDiscount       = 90
+)
+
+var (
+	ErrInvalidExpiryPolicy = errors.New("invalid expiry policy: hide and discount days must be 0-365 and the discount 0-90 percent")
+	ErrInvalidExpiryQuery  = errors.New("invalid expiry query: days must be 0-365")
+)
+
+// ListBatches returns the batches of a product that hold stock, by variant
+// and then first-expiring first, the order sales take them in.
+func (ps *ProductService) ListBatches(productID int) ([]models.StockBatch, error) {
+	if _, err := ps.getProduct(productID); err != nil {
+		return nil, err
+	}
+	return ps.productRepo.ListBatches(productID)
+}
+
+// ExpiringStock reports the stock of sellerID's products (every seller's
+// when zero) whose best-before date is at most days away, 7 by default.
+func (ps *ProductService) ExpiringStock(sellerID int, days *int) (*models.ExpiryReport, error) {
+	n := defaultExpiryReportDays
+	if days != nil {
+		n = *days
+	}
+	if n < 0 || n > maxExpiryDays {
+		return nil, ErrInvalidExpiryQuery
+	}
+	batches, err := ps.productRepo.ExpiringBatches(sellerID, n)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ExpiryReport{Days: n, Items: batches}, nil
+}
+
+// checkExpiryPolicy validates how near-expiry batches of a product are
+// hidden and discounted.
+func checkExpiryPolicy(e models.ExpiryPolicy) error {
+	if e.HideDays < 0 || e.HideDays > maxExpiryDays || e.DiscountDays < 0 || e.DiscountDays > maxExpiryDays ||
+		e.DiscountPercent < 0 || e.DiscountPercent > maxExpiryDiscount {
+		return ErrInvalidExpiryPolicy
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+var (
+	ErrCartEmpty        = errors.New("cart is empty")
+	ErrCartItemNotFound = errors.New("variant is not in the cart")
+)
+
+// CartService manages server-side carts. A logged-in user has one cart; an
+// anonymous visitor is identified by a random cart token (stored hashed),
+// and that cart is merged into the user's cart at login. Stock holds belong
+// to the checkout of the caller's login session, passed as sessionID (zero
+// for anonymous callers).
+type CartService struct {
+	cartRepo     *repositories.CartRepository
+	productRepo  *repositories.ProductRepository
+	reservations *ReservationService
+	currency     *CurrencyService
+	orders       *OrderService
+}
+
+func NewCartService(cr *repositories.CartRepository, pr *repositories.ProductRepository, rs *ReservationService, cs *CurrencyService, os *OrderService) *CartService {
+	return &CartService{cartRepo: cr, productRepo: pr, reservations: rs, currency: cs, orders: os}
+}
+
+// GetCart returns the caller's cart with prices and stock revalidated. A
+// caller without a cart gets an empty one.
+func (cs *CartService) GetCart(userID, sessionID int, token string) (*models.Cart, error) {
+	cart, err := cs.findCart(userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		return &models.Cart{UserID: userID, Currency: cs.currency.BaseCurrency(), Items: []models.CartItem{}, Valid: true}, nil
+	}
+	return cs.loadCart(cart, sessionID)
+}
+
+// AddItem adds quantity of a product variant to the caller's cart, creating
+// the cart when needed. variantID may be zero for products with a single
+// variant. For anonymous callers without a cart a new token is returned.
+func (cs *CartService) AddItem(userID, sessionID int, token string, productID, variantID int, quantity models.Quantity) (*models.Cart, string, error) {
+	product, err := cs.productRepo.GetProductByID(productID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrProductNotFound
+		}
+		return nil, "", err
+	}
+	if product.ArchivedAt != nil {
+		return nil, "", ErrProductNotFound
+	}
+	variant, err := resolveVariant(product, variantID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !models.RuleForUnit(variant.Unit).AllowsOrder(quantity) {
+		return nil, "", ErrInvalidQuantity
+	}
+
+	cartID, newToken, err := cs.ensureCart(userID, token)
+	if err != nil {
+		return nil, "", err
+	}
+	items, err := cs.cartRepo.ListItems(cartID, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	total := quantity
+	for _, item := range items {
+		if item.VariantID == variant.ID {
+			total += item.Quantity
+		}
+	}
+	available, err := cs.reservations.AvailableFor(variant.ID, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	if total > available {
+		return nil, "", ErrInsufficientStock
+	}
+
+	price, err := cs.currency.Convert(variant.Price, product.Currency, "")
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cs.cartRepo.AddItem(cartID, product.ID, variant.ID, quantity, price); err != nil {
+		return nil, "", err
+	}
+	cart, err := cs.loadCart(&models.Cart{ID: cartID, UserID: userID}, sessionID)
+	if err != nil {
+		return nil, "", err
+	}
+	return cart, newToken, nil
+}
+
+func (cs *CartService) UpdateItem(userID, sessionID int, token string, variantID int, quantity models.Quantity) (*models.Cart, error) {
+	cart, err := cs.findCart(userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		return nil, ErrCartItemNotFound
+	}
+	variant, err := cs.productRepo.GetVariant(variantID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCartItemNotFound
+		}
+		return nil, err
+	}
+	if !models.RuleForUnit(variant.Unit).AllowsOrder(quantity) {
+		return nil, ErrInvalidQuantity
+	}
+	available, err := cs.reservations.AvailableFor(variantID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if quantity > available {
+		return nil, ErrInsufficientStock
+	}
+	updated, err := cs.cartRepo.SetItemQuantity(cart.ID, variantID, quantity)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrCartItemNotFound
+	}
+	return cs.loadCart(cart, sessionID)
+}
+
+func (cs *CartService) RemoveItem(userID, sessionID int, token string, variantID int) (*models.Cart, error) {
+	cart, err := cs.findCart(userID, token)
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		return nil, ErrCartItemNotFound
+	}
+	removed, err := cs.cartRepo.RemoveItem(cart.ID, variantID)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrCartItemNotFound
+	}
+	return cs.loadCart(cart, sessionID)
+}
+
+func (cs *CartService) Clear(userID int, token string) error {
+	cart, err := cs.findCart(userID, token)
+	if err != nil || cart == nil {
+		return err
+	}
+	return cs.cartRepo.ClearCart(cart.ID)
+}
+
+// MergeAnonymousCart moves the cart behind token into the user's cart.
+// Quantities of variants present in both carts are added up; stock is
+// revalidated on the next read.
+func (cs *CartService) MergeAnonymousCart(token string, userID int) error {
+	if token == "" || userID <= 0 {
+		return nil
+	}
+	anon, err := cs.cartRepo.GetCartByTokenHash(hashToken(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	userCartID, err := cs.cartRepo.EnsureUserCart(userID)
+	if err != nil {
+		return err
+	}
+	return cs.cartRepo.MergeCarts(anon.ID, userCartID)
+}
+
+// Reserve holds stock for every line of the user's cart so it cannot be
+// sold to someone else during the checkout in session sessionID. Holds from
+// an earlier call in that session are replaced; they expire on their own
+// or are used up by Checkout.
+func (cs *CartService) Reserve(userID, sessionID int) (*models.Cart, error) {
+	cart, err := cs.findCart(userID, "")
+	if err != nil {
+		return nil, err
+	}
+	if cart == nil {
+		return nil, ErrCartEmpty
+	}
+	items, err := cs.cartRepo.ListItems(cart.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrCartEmpty
+	}
+	holds := make([]models.StockReservation, len(items))
+	for i, item := range items {
+		holds[i] = models.StockReservation{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+	}
+	if _, err := cs.reservations.Reserve(userID, sessionID, holds); err != nil {
+		return nil, err
+	}
+	return cs.loadCart(cart, sessionID)
+}
+
+// ReleaseReservation gives back the stock held for the user's checkout in
+// session sessionID.
+func (cs *CartService) ReleaseReservation(userID, sessionID int) (*models.Cart, error) {
+	if err := cs.reservations.Release(sessionID); err != nil {
+		return nil, err
+	}
+	return cs.GetCart(userID, sessionID, "")
+}
+
+// Checkout places an order for the user's cart through OrderService and
+// takes the ordered lines off the cart once the order exists, leaving what
+// was added meanwhile. Current prices apply.
+func (cs *CartService) Checkout(userID, sessionID int, currency, deliveryAddress, phoneNumber, comment string) (int, error) {
+	if userID <= 0 {
+		return 0, ErrInvalidOrder
+	}
+	cart, err := cs.findCart(userID, "")
+	if err != nil {
+		return 0, err
+	}
+	if cart == nil {
+		return 0, ErrCartEmpty
+	}
+	items, err := cs.cartRepo.ListItems(cart.ID, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) == 0 {
+		return 0, ErrCartEmpty
+	}
+
+	orderItems := make([]models.OrderItem, len(items))
+	for i, item := range items {
+		orderItems[i] = models.OrderItem{ProductID: item.ProductID, VariantID: item.VariantID, Quantity: item.Quantity}
+	}
+	orderID, err := cs.orders.PlaceOrder(userID, sessionID, orderItems, currency, deliveryAddress, phoneNumber, comment, "")
+	if err != nil {
+		return 0, err
+	}
+	if err := cs.cartRepo.RemoveOrderedItems(cart.ID, items); err != nil {
+		return orderID, err
+	}
+	return orderID, nil
+}
+
+func (cs *CartService) findCart(userID int, token string) (*models.Cart, error) {
+	var (
+		cart *models.Cart
+		err  error
+	)
+	switch {
+	case userID > 0:
+		cart, err = cs.cartRepo.GetCartByUserID(userID)
+	case token != "":
+		cart, err = cs.cartRepo.GetCartByTokenHash(hashToken(token))
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return cart, nil
+}
+
+func (cs *CartService) ensureCart(userID int, token string) (int, string, error) {
+	if userID > 0 {
+		id, err := cs.cartRepo.EnsureUserCart(userID)
+		return id, "", err
+	}
+	if token != "" {
+		cart, err := cs.cartRepo.GetCartByTokenHash(hashToken(token))
+		if err == nil {
+			return cart.ID, "", nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, "", err
+		}
+	}
+	newToken, err := randomToken()
+	if err != nil {
+		return 0, "", err
+	}
+	id, err := cs.cartRepo.CreateAnonymousCart(hashToken(newToken))
+	if err != nil {
+		return 0, "", err
+	}
+	return id, newToken, nil
+}
+
+// loadCart fills the cart's lines with current base-currency prices and
+// flags lines whose price or stock changed since they were added. For a
+// user's cart it also reports until when the holds of their checkout in
+// session sessionID last.
+func (cs *CartService) loadCart(cart *models.Cart, sessionID int) (*models.Cart, error) {
+	items, err := cs.cartRepo.ListItems(cart.ID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	cart.Currency = cs.currency.BaseCurrency()
+	cart.Items = items
+	cart.Total = 0
+	cart.Valid = true
+	for i := range cart.Items {
+		item := &cart.Items[i]
+		price, err := cs.currency.Convert(item.Price, item.ProductCurrency, "")
+		if err != nil {
+			return nil, err
+		}
+		item.Price = price
+		item.LineTotal = price.Mul(item.Quantity)
+		if price != item.AddedPrice {
+			item.Issues = append(item.Issues, models.CartIssuePriceChanged)
+		}
+		switch {
+		case item.Stock <= 0:
+			item.Issues = append(item.Issues, models.CartIssueOutOfStock)
+			cart.Valid = false
+		case item.Quantity > item.Stock:
+			item.Issues = append(item.Issues, models.CartIssueInsufficientStock)
+			cart.Valid = false
+		}
+		cart.Total += item.LineTotal
+	}
+
+	cart.ReservedUntil = nil
+	if cart.UserID > 0 && sessionID > 0 {
+		holds, err := cs.reservations.ListReservations(sessionID)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range holds {
+			if cart.ReservedUntil == nil || h.ExpiresAt.Before(*cart.ReservedUntil) {
+				expiresAt := h.ExpiresAt
+				cart.ReservedUntil = &expiresAt
+			}
+		}
+	}
+	return cart, nil
+}
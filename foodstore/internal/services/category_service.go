@@ -0,0 +1,285 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"unicode"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+var (
+	ErrCategoryNotFound  = errors.New("category not found")
+	ErrInvalidCategory   = errors.New("category name is required and the slug must contain a letter or digit")
+	ErrCategorySlugTaken = errors.New("category slug is already used")
+	ErrCategoryCycle     = errors.New("a category cannot be moved under itself or its subcategories")
+	ErrCategoryInUse     = errors.New("category has subcategories or products; move them first or pass move_to")
+)
+
+// Products whose free-text category has no letter or digit are filed under
+// this category when they are mapped.
+const (
+	uncategorizedName = "Uncategorized"
+	uncategorizedSlug = "uncategorized"
+)
+
+// CategoryService maintains the category tree that products are filed in.
+type CategoryService struct {
+	categoryRepo *repositories.CategoryRepository
+}
+
+func NewCategoryService(cr *repositories.CategoryRepository) *CategoryService {
+	return &CategoryService{categoryRepo: cr}
+}
+
+// Tree returns the root categories with their subcategories nested below
+// them. TotalCount adds up the products of each subtree.
+func (cs *CategoryService) Tree() ([]*models.Category, error) {
+	categories, err := cs.categoryRepo.ListCategories()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[int]*models.Category, len(categories))
+	for i := range categories {
+		byID[categories[i].ID] = &categories[i]
+	}
+	roots := []*models.Category{}
+	for i := range categories {
+		c := &categories[i]
+		if parent, ok := byID[c.ParentID]; ok {
+			parent.Children = append(parent.Children, c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	for _, root := range roots {
+		sumProductCounts(root)
+	}
+	return roots, nil
+}
+
+func sumProductCounts(c *models.Category) int {
+	c.TotalCount = c.ProductCount
+	for _, child := range c.Children {
+		c.TotalCount += sumProductCounts(child)
+	}
+	return c.TotalCount
+}
+
+// MapProductCategories files products that only carry a free-text category,
+// from before the category table existed, under the category with its slug,
+// creating it when needed. Spellings that only differ in case, spacing or
+// punctuation share a slug and so a category, named after the first
+// spelling in order.
+func (cs *CategoryService) MapProductCategories() error {
+	names, err := cs.categoryRepo.ListUnmappedCategories()
+	if err != nil {
+		return err
+	}
+	for _, category := range names {
+		name, slug := strings.TrimSpace(category), slugify(category)
+		if slug == "" {
+			name, slug = uncategorizedName, uncategorizedSlug
+		}
+		if err := cs.categoryRepo.MapProducts(category, name, slug); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolve finds the category a product is filed under: by id when set,
+// otherwise by slug or name. The slug is matched as slugify makes it, so
+// ref may also be spelt like a name.
+func (cs *CategoryService) Resolve(id int, ref string) (*models.Category, error) {
+	var (
+		category *models.Category
+		err      error
+	)
+	ref = strings.TrimSpace(ref)
+	switch {
+	case id > 0:
+		category, err = cs.categoryRepo.GetCategoryByID(id)
+	case ref != "":
+		category, err = cs.categoryRepo.FindCategory(ref, slugify(ref))
+	default:
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, err
+	}
+	return category, nil
+}
+
+// CreateCategory adds a category under parentID (zero for a root). The slug
+// is derived from the name when empty.
+func (cs *CategoryService) CreateCategory(name, slug string, parentID int) (*models.Category, error) {
+	c, err := cs.prepare(models.Category{Name: name, Slug: slug, ParentID: parentID})
+	if err != nil {
+		return nil, err
+	}
+	id, err := cs.categoryRepo.CreateCategory(c)
+	if errors.Is(err, repositories.ErrCategorySlugTaken) {
+		return nil, ErrCategorySlugTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cs.categoryRepo.GetCategoryByID(id)
+}
+
+// UpdateCategory renames or moves a category; products filed under it take
+// the new name. A nil name, slug or parentID keeps the stored value, and an
+// empty slug is derived from the name.
+func (cs *CategoryService) UpdateCategory(id int, name, slug *string, parentID *int) (*models.Category, error) {
+	if id <= 0 {
+		return nil, ErrCategoryNotFound
+	}
+	current, err := cs.categoryRepo.GetCategoryByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCategoryNotFound
+		}
+		return nil, err
+	}
+	if name != nil {
+		current.Name = *name
+	}
+	if slug != nil {
+		current.Slug = *slug
+	}
+	if parentID != nil {
+		current.ParentID = *parentID
+	}
+	c, err := cs.prepare(*current)
+	if err != nil {
+		return nil, err
+	}
+	if c.ParentID > 0 {
+		categories, err := cs.categoryRepo.ListCategories()
+		if err != nil {
+			return nil, err
+		}
+		parents := make(map[int]int, len(categories))
+		for _, other := range categories {
+			parents[other.ID] = other.ParentID
+		}
+		for ancestor := c.ParentID; ancestor != 0; ancestor = parents[ancestor] {
+			if ancestor == id {
+				return nil, ErrCategoryCycle
+			}
+		}
+	}
+	updated, err := cs.categoryRepo.UpdateCategory(c)
+	if errors.Is(err, repositories.ErrCategorySlugTaken) {
+		return nil, ErrCategorySlugTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrCategoryNotFound
+	}
+	return cs.categoryRepo.GetCategoryByID(id)
+}
+
+// DeleteCategory removes a category that has no subcategories. Products
+// still filed under it are moved to moveTo; without moveTo it must be empty.
+func (cs *CategoryService) DeleteCategory(id, moveTo int) error {
+	categories, err := cs.categoryRepo.ListCategories()
+	if err != nil {
+		return err
+	}
+	var target *models.Category
+	moveToExists := false
+	for i := range categories {
+		c := &categories[i]
+		switch {
+		case c.ID == id:
+			target = c
+		case c.ParentID == id:
+			return ErrCategoryInUse
+		}
+		if c.ID == moveTo {
+			moveToExists = true
+		}
+	}
+	if target == nil {
+		return ErrCategoryNotFound
+	}
+	if moveTo > 0 && (moveTo == id || !moveToExists) {
+		return ErrCategoryNotFound
+	}
+	if target.ProductCount > 0 && moveTo <= 0 {
+		return ErrCategoryInUse
+	}
+	// ProductCount leaves archived products out, which still keep the
+	// category from being deleted.
+	deleted, err := cs.categoryRepo.DeleteCategory(id, moveTo)
+	if errors.Is(err, repositories.ErrCategoryNotEmpty) {
+		return ErrCategoryInUse
+	}
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
+
+// prepare trims and validates a category, fills in its slug and checks the
+// parent exists and the slug is free.
+func (cs *CategoryService) prepare(c models.Category) (models.Category, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	if strings.TrimSpace(c.Slug) == "" {
+		c.Slug = c.Name
+	}
+	c.Slug = slugify(c.Slug)
+	if c.Name == "" || c.Slug == "" || c.ParentID < 0 {
+		return c, ErrInvalidCategory
+	}
+	if c.ParentID > 0 {
+		if c.ParentID == c.ID {
+			return c, ErrCategoryCycle
+		}
+		if _, err := cs.categoryRepo.GetCategoryByID(c.ParentID); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return c, ErrCategoryNotFound
+			}
+			return c, err
+		}
+	}
+	taken, err := cs.categoryRepo.SlugExists(c.Slug, c.ID)
+	if err != nil {
+		return c, err
+	}
+	if taken {
+		return c, ErrCategorySlugTaken
+	}
+	return c, nil
+}
+
+// slugify lower-cases s and joins its runs of letters and digits, in any
+// script, with hyphens.
+func slugify(s string) string {
+	var b strings.Builder
+	pendingHyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(s)) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pendingHyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			pendingHyphen = false
+			b.WriteRune(r)
+			continue
+		}
+		pendingHyphen = true
+	}
+	return b.String()
+}
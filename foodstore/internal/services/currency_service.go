@@ -0,0 +1,103 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+var (
+	ErrUnknownCurrency = errors.New("no exchange rate for currency")
+	ErrBaseCurrency    = errors.New("the base currency has a fixed rate of 1")
+	ErrCurrencyInUse   = errors.New("currency is used by products")
+)
+
+// CurrencyService converts amounts between the store's base currency and the
+// currencies listed in the exchange rate table. Rates are expressed in base
+// currency units per unit of the other currency.
+type CurrencyService struct {
+	rateRepo *repositories.ExchangeRateRepository
+	base     string
+}
+
+func NewCurrencyService(rr *repositories.ExchangeRateRepository, baseCurrency string) *CurrencyService {
+	return &CurrencyService{rateRepo: rr, base: baseCurrency}
+}
+
+func (cs *CurrencyService) BaseCurrency() string {
+	return cs.base
+}
+
+func (cs *CurrencyService) ListRates() ([]models.ExchangeRate, error) {
+	return cs.rateRepo.ListRates()
+}
+
+// RateFor returns the rate of currency against the base currency. An empty
+// code means the base currency.
+func (cs *CurrencyService) RateFor(currency string) (string, models.Rate, error) {
+	if currency == "" {
+		return cs.base, models.BaseRate, nil
+	}
+	code, err := models.NormalizeCurrency(currency)
+	if err != nil {
+		return "", 0, err
+	}
+	if code == cs.base {
+		return code, models.BaseRate, nil
+	}
+	rate, err := cs.rateRepo.GetRate(code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, ErrUnknownCurrency
+		}
+		return "", 0, err
+	}
+	return code, rate.Rate, nil
+}
+
+// Convert converts amount from one currency into another through the base.
+func (cs *CurrencyService) Convert(amount models.Money, from, to string) (models.Money, error) {
+	_, fromRate, err := cs.RateFor(from)
+	if err != nil {
+		return 0, err
+	}
+	_, toRate, err := cs.RateFor(to)
+	if err != nil {
+		return 0, err
+	}
+	return amount.Convert(fromRate, toRate), nil
+}
+
+func (cs *CurrencyService) SetRate(actorID int, currency string, rate models.Rate) error {
+	code, err := models.NormalizeCurrency(currency)
+	if err != nil {
+		return err
+	}
+	if code == cs.base {
+		return ErrBaseCurrency
+	}
+	if rate <= 0 {
+		return models.ErrInvalidRate
+	}
+	return cs.rateRepo.UpsertRate(code, rate, actorID)
+}
+
+func (cs *CurrencyService) DeleteRate(currency string) (bool, error) {
+	code, err := models.NormalizeCurrency(currency)
+	if err != nil {
+		return false, err
+	}
+	if code == cs.base {
+		return false, ErrBaseCurrency
+	}
+	used, err := cs.rateRepo.CurrencyInUse(code)
+	if err != nil {
+		return false, err
+	}
+	if used {
+		return false, ErrCurrencyInUse
+	}
+	return cs.rateRepo.DeleteRate(code)
+}
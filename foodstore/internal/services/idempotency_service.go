@@ -0,0 +1,121 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("idempotency key must be 1 to 255 visible ASCII characters")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still being processed")
+)
+
+const (
+	idempotencyKeyTTL = 24 * time.Hour
+	// idempotencyPendingTTL is how long a claimed key waits for its request
+	// to finish before a retry may take it over from a request that died.
+	idempotencyPendingTTL = time.Minute
+	maxIdempotencyKeyLen  = 255
+)
+
+// IdempotencyService lets clients retry a request safely. The first request
+// with a key claims it; once it succeeds its response is stored, and repeats
+// of the same request get that response back instead of running again.
+// Keys are scoped to the user and forgotten after a day; a key whose request
+// has not finished within a minute may be claimed again.
+type IdempotencyService struct {
+	idempotencyRepo *repositories.IdempotencyRepository
+}
+
+func NewIdempotencyService(ir *repositories.IdempotencyRepository) *IdempotencyService {
+	return &IdempotencyService{idempotencyRepo: ir}
+}
+
+// Begin claims key for the request body. It returns nil when the caller
+// should process the request, or the stored record whose response must be
+// replayed. A key reused for a different body, or whose first request has
+// not finished, is an error.
+func (is *IdempotencyService) Begin(userID int, key string, body []byte) (*models.IdempotencyKey, error) {
+	if !validIdempotencyKey(key) {
+		return nil, ErrInvalidIdempotencyKey
+	}
+	sum := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(sum[:])
+
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := is.idempotencyRepo.ClaimKey(userID, key, requestHash, time.Now().Add(-idempotencyPendingTTL))
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+		saved, err := is.idempotencyRepo.GetKey(userID, key)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+		if time.Since(saved.CreatedAt) > idempotencyKeyTTL {
+			if err := is.idempotencyRepo.DeleteKey(userID, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if saved.RequestHash != requestHash {
+			return nil, ErrIdempotencyKeyReused
+		}
+		if saved.CompletedAt == nil {
+			return nil, ErrIdempotencyInProgress
+		}
+		return saved, nil
+	}
+	return nil, ErrIdempotencyInProgress
+}
+
+// Complete stores the response of a request claimed with Begin.
+func (is *IdempotencyService) Complete(userID int, key string, statusCode int, response []byte) error {
+	return is.idempotencyRepo.CompleteKey(userID, key, statusCode, response)
+}
+
+// Release forgets a claimed key after the request failed, so it can be
+// retried with the same key. A key whose order was placed is kept.
+func (is *IdempotencyService) Release(userID int, key string) {
+	if err := is.idempotencyRepo.DeletePendingKey(userID, key); err != nil {
+		log.Printf("idempotency key release for user %d failed: %v", userID, err)
+	}
+}
+
+func (is *IdempotencyService) StartCleanup(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := is.idempotencyRepo.DeleteKeysBefore(time.Now().Add(-idempotencyKeyTTL))
+			if err != nil {
+				log.Printf("Background: idempotency key cleanup failed: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Background: removed %d expired idempotency keys", removed)
+			}
+		}
+	}()
+}
+
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLen {
+		return false
+	}
+	return !strings.ContainsFunc(key, func(r rune) bool { return r < 0x21 || r > 0x7e })
+}
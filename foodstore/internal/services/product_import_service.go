@@ -0,0 +1,327 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"foodstore/internal/models"
+)
+
+const (
+	maxImportRows     = 1000
+	maxImageURLLen    = 2048
+	exportPageSize    = 200
+	defaultImportUnit = models.UnitPiece
+)
+
+// productCSVColumns are the columns of a product CSV, in export order. An
+// import may order them freely and leave out the optional ones.
+var productCSVColumns = []string{"sku", "name", "variant_name", "description", "price", "currency", "stock", "category", "unit", "image_url"}
+
+var optionalImportColumns = map[string]bool{"sku": true, "variant_name": true, "currency": true, "image_url": true}
+
+var ErrInvalidImport = errors.New("invalid import: send a CSV file whose header row names the columns sku, name, variant_name, description, price, currency, stock, category, unit and image_url (sku, variant_name, currency and image_url may be left out), with 1-1000 rows")
+
+// importRow is a CSV row turned into the product it creates or updates.
+type importRow struct {
+	line    int
+	product models.Product
+}
+
+// ImportProducts creates and updates sellerID's products from CSV. A row
+// whose sku is a variant of the seller's updates that variant (price, unit,
+// and the name unless variant_name is empty) and its product (name,
+// description, category, and the currency and image unless empty), unless
+// the product is archived; a row without a known sku creates a product with
+// a single variant, priced in the row's currency or else the base currency.
+// Every row is validated before anything is written, and nothing is written
+// when any row fails or on a dry run. Errors of the file as a whole wrap
+// ErrInvalidImport.
+func (ps *ProductService) ImportProducts(r io.Reader, sellerID int, dryRun bool) (*models.ProductImport, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrInvalidImport
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w (%w)", ErrInvalidImport, err)
+	}
+	columns, err := importColumns(header)
+	if err != nil {
+		return nil, err
+	}
+	baseCurrency, _, err := ps.currency.RateFor("")
+	if err != nil {
+		return nil, err
+	}
+
+	report := &models.ProductImport{
+		DryRun: dryRun,
+		Rows:   []models.ProductImportRow{},
+		Errors: []models.ProductImportError{},
+	}
+	var rows []importRow
+	skuLines := map[string]int{}
+	currencies := map[int]string{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.Is(err, csv.ErrFieldCount) || !errors.As(err, &parseErr) {
+				return nil, fmt.Errorf("%w (%w)", ErrInvalidImport, err)
+			}
+			report.Errors = append(report.Errors, models.ProductImportError{Line: parseErr.StartLine, Message: "the row has another number of fields than the header"})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		if len(rows)+len(report.Errors) >= maxImportRows {
+			return nil, ErrInvalidImport
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		row, rowErrors, err := ps.importRow(line, field, sellerID, baseCurrency, skuLines, currencies)
+		if err != nil {
+			return nil, err
+		}
+		if len(rowErrors) > 0 {
+			report.Errors = append(report.Errors, rowErrors...)
+			continue
+		}
+		rows = append(rows, row)
+		action := models.ProductImportCreate
+		if row.product.ID != 0 {
+			action = models.ProductImportUpdate
+			report.Updated++
+		} else {
+			report.Created++
+		}
+		report.Rows = append(report.Rows, models.ProductImportRow{
+			Line:      line,
+			SKU:       row.product.Variants[0].SKU,
+			Action:    action,
+			ProductID: row.product.ID,
+		})
+	}
+	if len(rows)+len(report.Errors) == 0 {
+		return nil, ErrInvalidImport
+	}
+	if dryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	products := make([]models.Product, len(rows))
+	for i := range rows {
+		products[i] = rows[i].product
+	}
+	ids, err := ps.productRepo.ImportProducts(products, sellerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	for i := range report.Rows {
+		report.Rows[i].ProductID = ids[i]
+	}
+	report.Applied = true
+	return report, nil
+}
+
+// importColumns maps the header of a product CSV to column positions.
+func importColumns(header []string) (map[string]int, error) {
+	known := make(map[string]bool, len(productCSVColumns))
+	for _, name := range productCSVColumns {
+		known[name] = true
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			// Spreadsheets often start UTF-8 files with a byte order mark.
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !known[name] {
+			return nil, fmt.Errorf("%w (unknown column %q)", ErrInvalidImport, name)
+		}
+		if _, dup := columns[name]; dup {
+			return nil, fmt.Errorf("%w (column %q appears twice)", ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	for _, name := range productCSVColumns {
+		if _, ok := columns[name]; !ok && !optionalImportColumns[name] {
+			return nil, fmt.Errorf("%w (missing column %q)", ErrInvalidImport, name)
+		}
+	}
+	return columns, nil
+}
+
+// importRow validates one CSV row of sellerID's import. skuLines holds the
+// line of every SKU seen so far, so a SKU is imported only once, and
+// currencies the currency given for each updated product, which its other
+// rows must not contradict.
+func (ps *ProductService) importRow(line int, field func(string) string, sellerID int, baseCurrency string, skuLines map[string]int, currencies map[int]string) (importRow, []models.ProductImportError, error) {
+	var rowErrors []models.ProductImportError
+	fail := func(column, message string) {
+		rowErrors = append(rowErrors, models.ProductImportError{Line: line, Column: column, Message: message})
+	}
+
+	p := models.Product{
+		Name:        field("name"),
+		Description: field("description"),
+		ImageURL:    field("image_url"),
+	}
+	v := models.ProductVariant{SKU: field("sku"), Name: field("variant_name"), Unit: strings.ToLower(field("unit"))}
+	if p.Name == "" {
+		fail("name", "name is required")
+	}
+	if p.Description == "" {
+		fail("description", "description is required")
+	}
+	price, err := models.ParseMoney(field("price"))
+	if err != nil || price < 0 {
+		fail("price", "price must be an amount >= 0 with at most two decimals")
+	}
+	v.Price = price
+	if currency := field("currency"); currency != "" {
+		code, _, err := ps.currency.RateFor(currency)
+		switch {
+		case errors.Is(err, ErrUnknownCurrency), errors.Is(err, models.ErrInvalidCurrency):
+			fail("currency", "currency must be the base currency or one with an exchange rate")
+		case err != nil:
+			return importRow{}, nil, err
+		default:
+			p.Currency = code
+		}
+	}
+	if v.Unit == "" {
+		v.Unit = defaultImportUnit
+	}
+	switch v.Unit {
+	case models.UnitKg, models.UnitPiece, models.UnitPack:
+		rule := models.RuleForUnit(v.Unit)
+		stock, err := models.ParseQuantity(field("stock"))
+		if err != nil || !rule.OnStep(stock) {
+			fail("stock", fmt.Sprintf("stock must be a multiple of %s for unit %s", rule.Step, v.Unit))
+		}
+		v.Stock = stock
+	default:
+		fail("unit", "unit must be kg, piece or pack")
+	}
+
+	if category := field("category"); category == "" {
+		fail("category", "category is required")
+	} else {
+		resolved, err := ps.categories.Resolve(0, category)
+		switch {
+		case errors.Is(err, ErrCategoryNotFound):
+			fail("category", "unknown category")
+		case err != nil:
+			return importRow{}, nil, err
+		default:
+			p.CategoryID = resolved.ID
+			p.Category = resolved.Name
+		}
+	}
+
+	var current *models.Product
+	if v.SKU != "" {
+		if len(v.SKU) > maxVariantCodeLen || strings.ContainsAny(v.SKU, " \t\r\n") {
+			fail("sku", "sku must be at most 64 characters without spaces")
+		} else if first, seen := skuLines[v.SKU]; seen {
+			fail("sku", fmt.Sprintf("sku is already imported on line %d", first))
+		} else {
+			skuLines[v.SKU] = line
+			variant, err := ps.productRepo.GetVariantBySKU(v.SKU, sellerID)
+			switch {
+			case errors.Is(err, sql.ErrNoRows):
+			case err != nil:
+				return importRow{}, nil, err
+			default:
+				current, err = ps.getProduct(variant.ProductID)
+				if err != nil {
+					return importRow{}, nil, err
+				}
+				if current.ArchivedAt != nil {
+					fail("sku", "sku belongs to an archived product; restore it first")
+					current = nil
+				} else {
+					p.ID = current.ID
+					v.ID = variant.ID
+					v.ProductID = current.ID
+				}
+			}
+		}
+	}
+
+	// New images must be links; a product's own image (an upload, as
+	// exported) may be kept.
+	if p.ImageURL != "" && (current == nil || p.ImageURL != current.ImageURL) {
+		u, err := url.Parse(p.ImageURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(p.ImageURL) > maxImageURLLen {
+			fail("image_url", "image_url must be an http or https link")
+		}
+	}
+
+	// An update row without a currency keeps the product's; its other rows
+	// may not ask for two different ones.
+	switch {
+	case current == nil && p.Currency == "":
+		p.Currency = baseCurrency
+	case current != nil && p.Currency != "":
+		if other, seen := currencies[current.ID]; seen && other != p.Currency {
+			fail("currency", fmt.Sprintf("another row gives this product the currency %s", other))
+		}
+		currencies[current.ID] = p.Currency
+	}
+
+	p.Variants = []models.ProductVariant{v}
+	return importRow{line: line, product: p}, rowErrors, nil
+}
+
+// ExportProducts writes sellerID's products as CSV in the format
+// ImportProducts reads, one row per variant, by product name. Prices are in
+// each product's currency, which the currency column names.
+func (ps *ProductService) ExportProducts(w io.Writer, sellerID int) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(productCSVColumns); err != nil {
+		return err
+	}
+	for offset := 0; ; offset += exportPageSize {
+		products, _, err := ps.productRepo.ListProducts(models.ProductQuery{
+			SellerID: sellerID,
+			Sort:     models.ProductSortNameAsc,
+			Limit:    exportPageSize,
+			Offset:   offset,
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range products {
+			for _, v := range p.Variants {
+				record := []string{v.SKU, p.Name, v.Name, p.Description, v.Price.String(), p.Currency, v.Stock.String(), p.Category, v.Unit, p.ImageURL}
+				if err := writer.Write(record); err != nil {
+					return err
+				}
+			}
+		}
+		if len(products) < exportPageSize {
+			break
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
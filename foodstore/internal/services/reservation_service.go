@@ -0,0 +1,74 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+// ReservationService holds stock for a buyer while they check out. Holds
+// belong to the checkout of one login session, so checkouts of the same
+// user in other sessions keep their own. A hold keeps other checkouts from
+// taking the units until it expires or the order is placed; products.stock itself only changes when an order is
+// placed or cancelled.
+type ReservationService struct {
+	reservationRepo *repositories.ReservationRepository
+	ttl             time.Duration
+}
+
+func NewReservationService(rr *repositories.ReservationRepository, ttl time.Duration) *ReservationService {
+	return &ReservationService{reservationRepo: rr, ttl: ttl}
+}
+
+// Reserve replaces the holds of the user's checkout in session sessionID
+// with holds on the given quantities for the configured time and returns
+// them.
+func (rs *ReservationService) Reserve(userID, sessionID int, holds []models.StockReservation) ([]models.StockReservation, error) {
+	if userID <= 0 || sessionID <= 0 || len(holds) == 0 {
+		return nil, ErrInvalidOrder
+	}
+	if err := rs.reservationRepo.ReplaceReservations(userID, sessionID, holds, rs.ttl); err != nil {
+		if errors.Is(err, repositories.ErrInsufficientStock) {
+			return nil, ErrInsufficientStock
+		}
+		return nil, err
+	}
+	return rs.reservationRepo.ListReservations(sessionID)
+}
+
+// Release gives back the holds of the checkout in session sessionID.
+func (rs *ReservationService) Release(sessionID int) error {
+	return rs.reservationRepo.DeleteReservations(sessionID)
+}
+
+func (rs *ReservationService) ListReservations(sessionID int) ([]models.StockReservation, error) {
+	return rs.reservationRepo.ListReservations(sessionID)
+}
+
+// AvailableFor returns how much of a variant the checkout in session
+// sessionID can buy, counting every active hold but its own.
+func (rs *ReservationService) AvailableFor(variantID, sessionID int) (models.Quantity, error) {
+	return rs.reservationRepo.AvailableFor(variantID, sessionID)
+}
+
+// StartSweeper periodically deletes expired holds. Expired holds already stop
+// counting against stock; the sweeper only keeps the table small.
+func (rs *ReservationService) StartSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := rs.reservationRepo.DeleteExpiredReservations()
+			if err != nil {
+				log.Printf("Background: reservation sweep failed: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("Background: released %d expired stock reservations", removed)
+			}
+		}
+	}()
+}
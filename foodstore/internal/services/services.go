@@ -1,60 +1,292 @@
 package services
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"regexp"
 	"strings"
 	"time"
 
 	"foodstore/internal/models"
+	"foodstore/internal/policy"
 	"foodstore/internal/repositories"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type ProductService struct {
 	productRepo *repositories.ProductRepository
+	currency    *CurrencyService
+	categories  *CategoryService
 }
 
-func NewProductService(pr *repositories.ProductRepository) *ProductService {
-	return &ProductService{productRepo: pr}
+func NewProductService(pr *repositories.ProductRepository, cs *CurrencyService, cats *CategoryService) *ProductService {
+	return &ProductService{productRepo: pr, currency: cs, categories: cats}
 }
 
-func (ps *ProductService) ListProducts() ([]models.Product, error) {
-	products, err := ps.productRepo.GetAllProducts()
+const (
+	defaultProductPageSize = 20
+	maxProductPageSize     = 100
+)
+
+var (
+	ErrInvalidProductQuery = errors.New("invalid product query: sort must be newest, price_asc, price_desc, name_asc, name_desc or, when searching, relevance; limit 1-100, offset >= 0")
+	ErrEmptySearch         = errors.New("search query must contain a letter or digit")
+)
+
+const maxSearchTerms = 8
+
+var searchTermPattern = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// ListProducts returns one page of the catalogue. Price bounds in q are given
+// in currency, as are the display prices of the page (the base currency when
+// empty).
+func (ps *ProductService) ListProducts(q models.ProductQuery, currency string) (*models.ProductPage, error) {
+	if q.Sort == "" {
+		q.Sort = models.ProductSortNewest
+	}
+	switch q.Sort {
+	case models.ProductSortNewest, models.ProductSortPriceAsc, models.ProductSortPriceDesc, models.ProductSortNameAsc, models.ProductSortNameDesc:
+	case models.ProductSortRelevance:
+		if q.Search == "" {
+			return nil, ErrInvalidProductQuery
+		}
+	default:
+		return nil, ErrInvalidProductQuery
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultProductPageSize
+	}
+	if q.Limit < 0 || q.Limit > maxProductPageSize || q.Offset < 0 {
+		return nil, ErrInvalidProductQuery
+	}
+
+	_, rate, err := ps.currency.RateFor(currency)
 	if err != nil {
 		return nil, err
 	}
-	return products, nil
-}
+	if q.MinPrice != nil {
+		base := q.MinPrice.Convert(rate, models.BaseRate)
+		q.MinPrice = &base
+	}
+	if q.MaxPrice != nil {
+		base := q.MaxPrice.Convert(rate, models.BaseRate)
+		q.MaxPrice = &base
+	}
 
-func (ps *ProductService) ListProductsBySellerID(sellerID int) ([]models.Product, error) {
-	products, err := ps.productRepo.GetProductsBySellerID(sellerID)
+	products, total, err := ps.productRepo.ListProducts(q)
 	if err != nil {
 		return nil, err
 	}
-	return products, nil
+	if err := ps.ApplyDisplayCurrency(products, currency); err != nil {
+		return nil, err
+	}
+	return &models.ProductPage{
+		Items:   products,
+		Total:   total,
+		Limit:   q.Limit,
+		Offset:  q.Offset,
+		HasMore: q.Offset+len(products) < total,
+	}, nil
+}
+
+// SearchProducts runs a full-text search over name, category and
+// description. Every word must match and is matched as a prefix, so results
+// follow as-you-type input. Results are ranked by relevance unless q sets
+// another sort.
+func (ps *ProductService) SearchProducts(text string, q models.ProductQuery, currency string) (*models.ProductPage, error) {
+	search := searchQuery(text)
+	if search == "" {
+		return nil, ErrEmptySearch
+	}
+	q.Search = search
+	if q.Sort == "" {
+		q.Sort = models.ProductSortRelevance
+	}
+	return ps.ListProducts(q, currency)
+}
+
+// searchQuery turns free text into a to_tsquery expression: each word is a
+// prefix match and all words are required. Only letters and digits are
+// kept, so the input cannot inject tsquery operators.
+func searchQuery(text string) string {
+	terms := searchTermPattern.FindAllString(strings.ToLower(text), maxSearchTerms)
+	for i, term := range terms {
+		terms[i] = term + ":*"
+	}
+	return strings.Join(terms, " & ")
+}
+
+// ApplyDisplayCurrency fills DisplayPrice and DisplayCurrency with each
+// price converted into currency, or into the base currency when empty.
+// Each currency's rate is looked up once per call.
+func (ps *ProductService) ApplyDisplayCurrency(products []models.Product, currency string) error {
+	code, toRate, err := ps.currency.RateFor(currency)
+	if err != nil {
+		return err
+	}
+	rates := map[string]models.Rate{}
+	for i := range products {
+		fromRate, ok := rates[products[i].Currency]
+		if !ok {
+			_, fromRate, err = ps.currency.RateFor(products[i].Currency)
+			if err != nil {
+				return err
+			}
+			rates[products[i].Currency] = fromRate
+		}
+		converted := products[i].Price.Convert(fromRate, toRate)
+		products[i].DisplayPrice = &converted
+		products[i].DisplayCurrency = code
+		for j := range products[i].Variants {
+			v := &products[i].Variants[j]
+			variantPrice := v.Price.Convert(fromRate, toRate)
+			v.DisplayPrice = &variantPrice
+			if v.DiscountPrice != nil {
+				discountPrice := v.DiscountPrice.Convert(fromRate, toRate)
+				v.DisplayDiscountPrice = &discountPrice
+			}
+		}
+	}
+	return nil
 }
 
 func (ps *ProductService) CreateProduct(p models.Product, sellerID int) (int, error) {
 	p.SellerID = sellerID
+	if err := checkExpiryPolicy(p.Expiry); err != nil {
+		return 0, err
+	}
+	code, _, err := ps.currency.RateFor(p.Currency)
+	if err != nil {
+		return 0, err
+	}
+	p.Currency = code
+	if err := ps.fileUnderCategory(&p); err != nil {
+		return 0, err
+	}
+	if len(p.Variants) == 0 {
+		return 0, ErrLastVariant
+	}
+	for i := range p.Variants {
+		p.Variants[i].ID = 0
+		if err := ps.checkVariant(&p.Variants[i], p.SellerID); err != nil {
+			return 0, err
+		}
+	}
 	return ps.productRepo.CreateProduct(p)
 }
 
+// UpdateProduct keeps the product's currency when p.Currency is empty.
 func (ps *ProductService) UpdateProduct(p models.Product, sellerID int) (bool, error) {
 	p.SellerID = sellerID
+	if err := checkExpiryPolicy(p.Expiry); err != nil {
+		return false, err
+	}
+	if err := ps.checkCurrency(&p); err != nil {
+		return false, err
+	}
+	if err := ps.fileUnderCategory(&p); err != nil {
+		return false, err
+	}
+	if err := ps.checkDefaultVariant(&p); err != nil {
+		return false, err
+	}
 	return ps.productRepo.UpdateProduct(p)
 }
 
 func (ps *ProductService) UpdateProductAsAdmin(p models.Product) (bool, error) {
+	if err := checkExpiryPolicy(p.Expiry); err != nil {
+		return false, err
+	}
+	if err := ps.checkCurrency(&p); err != nil {
+		return false, err
+	}
+	if err := ps.fileUnderCategory(&p); err != nil {
+		return false, err
+	}
+	if err := ps.checkDefaultVariant(&p); err != nil {
+		return false, err
+	}
 	return ps.productRepo.UpdateProductAsAdmin(p)
 }
 
-func (ps *ProductService) DeleteProduct(id int, sellerID int) (bool, error) {
-	return ps.productRepo.DeleteProduct(id, sellerID)
+// checkDefaultVariant validates p.Variants[0], which updates the product's
+// first variant, when it is given.
+func (ps *ProductService) checkDefaultVariant(p *models.Product) error {
+	if len(p.Variants) == 0 {
+		return nil
+	}
+	current, err := ps.getProduct(p.ID)
+	if err != nil {
+		return err
+	}
+	if len(current.Variants) == 0 {
+		return ErrVariantNotFound
+	}
+	p.Variants = p.Variants[:1]
+	p.Variants[0].ID = current.Variants[0].ID
+	return ps.checkVariant(&p.Variants[0], current.SellerID)
+}
+
+// fileUnderCategory resolves p.CategoryID, or else p.Category as a slug or
+// name, to an existing category and stores both on p.
+func (ps *ProductService) fileUnderCategory(p *models.Product) error {
+	category, err := ps.categories.Resolve(p.CategoryID, p.Category)
+	if err != nil {
+		return err
+	}
+	p.CategoryID = category.ID
+	p.Category = category.Name
+	return nil
+}
+
+func (ps *ProductService) checkCurrency(p *models.Product) error {
+	if p.Currency == "" {
+		return nil
+	}
+	code, _, err := ps.currency.RateFor(p.Currency)
+	if err != nil {
+		return err
+	}
+	p.Currency = code
+	return nil
+}
+
+// ArchiveProduct takes a seller's product off sale and out of the
+// catalogue; orders keep referring to it.
+func (ps *ProductService) ArchiveProduct(id int, sellerID int) (bool, error) {
+	return ps.productRepo.ArchiveProduct(id, sellerID)
 }
 
-func (ps *ProductService) DeleteProductAsAdmin(id int) (bool, error) {
-	return ps.productRepo.DeleteProductAsAdmin(id)
+func (ps *ProductService) ArchiveProductAsAdmin(id int) (bool, error) {
+	return ps.productRepo.ArchiveProductAsAdmin(id)
+}
+
+func (ps *ProductService) RestoreProduct(id int, sellerID int) (bool, error) {
+	return ps.productRepo.RestoreProduct(id, sellerID)
+}
+
+func (ps *ProductService) RestoreProductAsAdmin(id int) (bool, error) {
+	return ps.productRepo.RestoreProductAsAdmin(id)
+}
+
+// PurgeProduct deletes an archived product that no order refers to.
+func (ps *ProductService) PurgeProduct(id int) error {
+	purged, err := ps.productRepo.PurgeProduct(id)
+	switch {
+	case errors.Is(err, repositories.ErrProductNotArchived):
+		return ErrProductNotArchived
+	case errors.Is(err, repositories.ErrProductReferenced):
+		return ErrProductReferenced
+	case err != nil:
+		return err
+	case !purged:
+		return ErrProductNotFound
+	}
+	return nil
 }
 
 func (ps *ProductService) GetProductByID(id int) (*models.Product, error) {
@@ -65,22 +297,80 @@ type OrderService struct {
 	orderRepo   *repositories.OrderRepository
 	productRepo *repositories.ProductRepository
 	userRepo    *repositories.UserRepository
+	currency    *CurrencyService
+	policy      *policy.Policy
 }
 
 var (
-	ErrUserNotFound      = errors.New("user not found")
-	ErrProductNotFound   = errors.New("product not found")
-	ErrInvalidOrder      = errors.New("invalid order")
-	ErrInsufficientStock = errors.New("insufficient stock")
-	ErrSellerRequired    = errors.New("seller role required")
-	ErrAdminRequired     = errors.New("administrator role required")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrProductNotFound    = errors.New("product not found")
+	ErrProductNotArchived = errors.New("archive the product before purging it")
+	ErrProductReferenced  = errors.New("the product appears in orders and can only be archived")
+	ErrInvalidOrder       = errors.New("invalid order")
+	ErrInsufficientStock  = errors.New("insufficient stock")
+	ErrInvalidQuantity    = errors.New("quantity does not match the product unit")
+	ErrSellerRequired     = errors.New("seller role required")
+	ErrAdminRequired      = errors.New("administrator role required")
+	ErrOrderNotFound      = errors.New("order not found")
+	ErrInvalidStatus      = errors.New("invalid order status")
+	ErrStatusTransition   = errors.New("order status transition not allowed")
+	ErrOrderForbidden     = errors.New("not allowed to manage this order")
+	ErrNotCancellable     = errors.New("order can no longer be cancelled")
 )
 
-func NewOrderService(or *repositories.OrderRepository, pr *repositories.ProductRepository, ur *repositories.UserRepository) *OrderService {
-	return &OrderService{orderRepo: or, productRepo: pr, userRepo: ur}
+// orderTransitions is the order lifecycle: each status lists the statuses it
+// may move to next. Statuses without entries are terminal.
+var orderTransitions = map[string][]string{
+	models.OrderStatusPending:   {models.OrderStatusConfirmed, models.OrderStatusCancelled},
+	models.OrderStatusConfirmed: {models.OrderStatusPacked, models.OrderStatusCancelled},
+	models.OrderStatusPacked:    {models.OrderStatusShipped, models.OrderStatusCancelled},
+	models.OrderStatusShipped:   {models.OrderStatusDelivered},
+	models.OrderStatusDelivered: {models.OrderStatusRefunded},
+	models.OrderStatusCancelled: nil,
+	models.OrderStatusRefunded:  nil,
+}
+
+// buyerCancellableStatuses are the statuses in which a buyer may still cancel
+// their own order; later cancellations go through the seller or administrator.
+var buyerCancellableStatuses = map[string]bool{
+	models.OrderStatusPending:   true,
+	models.OrderStatusConfirmed: true,
+}
+
+func isKnownOrderStatus(status string) bool {
+	_, ok := orderTransitions[status]
+	return ok
+}
+
+func canTransitionOrder(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+func nextOrderStatuses(from string) []string {
+	return append([]string{}, orderTransitions[from]...)
 }
 
-func (os *OrderService) PlaceOrder(userID int, items []models.OrderItem, deliveryAddress, phoneNumber, comment string) (int, error) {
+func NewOrderService(or *repositories.OrderRepository, pr *repositories.ProductRepository, ur *repositories.UserRepository, cs *CurrencyService, pol *policy.Policy) *OrderService {
+	return &OrderService{orderRepo: or, productRepo: pr, userRepo: ur, currency: cs, policy: pol}
+}
+
+// PlaceOrder prices the order in currency (the base currency when empty).
+// Each item buys a variant of its product; VariantID may be left out for
+// products with a single variant. Variant prices are converted at the
+// current rates and the order records the currency together with its rate
+// against the base. Units from near-expiry batches on discount are sold
+// first, as a separate line at the discounted price; CreateOrder splits
+// those lines off by the batches the sale takes. Stock is checked by
+// CreateOrder inside the order transaction, where the holds of the buyer's
+// checkout in session sessionID count as available. A non-empty
+// idempotencyKey claimed through IdempotencyService records the order in
+// that transaction.
+func (os *OrderService) PlaceOrder(userID, sessionID int, items []models.OrderItem, currency, deliveryAddress, phoneNumber, comment, idempotencyKey string) (int, error) {
 	if userID <= 0 || len(items) == 0 {
 		return 0, ErrInvalidOrder
 	}
@@ -97,7 +387,11 @@ func (os *OrderService) PlaceOrder(userID int, items []models.OrderItem, deliver
 	if !exists {
 		return 0, ErrUserNotFound
 	}
-	var total float64
+	currency, orderRate, err := os.currency.RateFor(currency)
+	if err != nil {
+		return 0, err
+	}
+	discounts := map[int]repositories.ExpiryDiscount{}
 	for i := range items {
 		if items[i].ProductID <= 0 || items[i].Quantity <= 0 {
 			return 0, ErrInvalidOrder
@@ -109,18 +403,42 @@ func (os *OrderService) PlaceOrder(userID int, items []models.OrderItem, deliver
 			}
 			return 0, err
 		}
-		if product.Stock < items[i].Quantity {
-			return 0, ErrInsufficientStock
+		if product.ArchivedAt != nil {
+			return 0, ErrProductNotFound
+		}
+		variant, err := resolveVariant(product, items[i].VariantID)
+		if err != nil {
+			return 0, err
+		}
+		if !models.RuleForUnit(variant.Unit).AllowsOrder(items[i].Quantity) {
+			return 0, ErrInvalidQuantity
+		}
+		_, productRate, err := os.currency.RateFor(product.Currency)
+		if err != nil {
+			return 0, err
+		}
+		items[i].VariantID = variant.ID
+		items[i].SKU = variant.SKU
+		items[i].VariantName = variant.Name
+		items[i].UnitPrice = variant.Price.Convert(productRate, orderRate)
+		items[i].ProductName = product.Name
+		items[i].ProductUnit = variant.Unit
+		items[i].ProductImageURL = product.ImageURL
+		if percent := product.Expiry.DiscountPercent; percent > 0 {
+			discounts[variant.ID] = repositories.ExpiryDiscount{
+				Percent:   percent,
+				UnitPrice: variant.Price.PercentOff(percent).Convert(productRate, orderRate),
+			}
 		}
-		items[i].UnitPrice = product.Price
-		items[i].LineTotal = product.Price * float64(items[i].Quantity)
-		total += items[i].LineTotal
 	}
-	orderID, err := os.orderRepo.CreateOrder(userID, items, total, deliveryAddress, phoneNumber, comment)
+	orderID, err := os.orderRepo.CreateOrder(userID, sessionID, items, discounts, currency, orderRate, deliveryAddress, phoneNumber, comment, idempotencyKey)
 	if err != nil {
 		if errors.Is(err, repositories.ErrInsufficientStock) {
 			return 0, ErrInsufficientStock
 		}
+		if errors.Is(err, repositories.ErrIdempotencyKeyTaken) {
+			return 0, ErrIdempotencyInProgress
+		}
 		return 0, err
 	}
 	return orderID, nil
@@ -137,7 +455,27 @@ func (os *OrderService) ListOrdersByUserID(userID int) ([]models.Order, error) {
 	if !exists {
 		return nil, ErrUserNotFound
 	}
-	return os.orderRepo.ListOrdersByUserID(userID)
+	orders, err := os.orderRepo.ListOrdersByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	orderIDs := make([]int, len(orders))
+	for i := range orders {
+		orderIDs[i] = orders[i].ID
+	}
+	fulfillments, err := os.orderRepo.ListFulfillments(orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	history, err := os.orderRepo.ListStatusHistory(orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].Fulfillments = fulfillments[orders[i].ID]
+		orders[i].History = history[orders[i].ID]
+	}
+	return orders, nil
 }
 
 func (os *OrderService) ListOrdersForSeller(sellerID int) ([]models.SellerOrder, error) {
@@ -152,20 +490,327 @@ func (os *OrderService) ListOrdersForSeller(sellerID int) ([]models.SellerOrder,
 		}
 		return nil, err
 	}
-	if seller.Role != "seller" && seller.Role != "administrator" {
+	if !os.policy.Can(seller.Role, policy.OrderReadSeller) {
 		return nil, ErrSellerRequired
 	}
 
-	return os.orderRepo.ListOrdersForSeller(sellerID)
+	orders, err := os.orderRepo.ListOrdersForSeller(sellerID)
+	if err != nil {
+		return nil, err
+	}
+	orderIDs := make([]int, len(orders))
+	for i := range orders {
+		orderIDs[i] = orders[i].ID
+	}
+	history, err := os.orderRepo.ListStatusHistory(orderIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range orders {
+		orders[i].NextStatuses = nextOrderStatuses(orders[i].Status)
+		orders[i].History = historyVisibleToSeller(history[orders[i].ID], sellerID)
+	}
+	return orders, nil
+}
+
+func (os *OrderService) GetOrderHistory(actorID, orderID int) ([]models.OrderStatusChange, error) {
+	if actorID <= 0 || orderID <= 0 {
+		return nil, ErrInvalidOrder
+	}
+	order, err := os.orderRepo.GetOrderByID(orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	actor, err := os.userRepo.GetUserByID(actorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	canView, err := os.canViewOrder(actor, order)
+	if err != nil {
+		return nil, err
+	}
+	if !canView {
+		return nil, ErrOrderNotFound
+	}
+
+	history, err := os.orderRepo.ListStatusHistory([]int{orderID})
+	if err != nil {
+		return nil, err
+	}
+	changes := history[orderID]
+	if order.UserID != actor.ID && !os.policy.Can(actor.Role, policy.OrderReadAny) {
+		changes = historyVisibleToSeller(changes, actor.ID)
+	}
+	if changes == nil {
+		return []models.OrderStatusChange{}, nil
+	}
+	return changes, nil
+}
+
+// GetOrder returns one order with its lines, parts and history. The buyer and
+// roles allowed to read any order see everything; a seller sees only their
+// own lines and part, and the total is limited to those lines.
+func (os *OrderService) GetOrder(actorID, orderID int) (*models.Order, error) {
+	if actorID <= 0 || orderID <= 0 {
+		return nil, ErrInvalidOrder
+	}
+	order, err := os.orderRepo.GetOrderByID(orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	actor, err := os.userRepo.GetUserByID(actorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	canView, err := os.canViewOrder(actor, order)
+	if err != nil {
+		return nil, err
+	}
+	if !canView {
+		return nil, ErrOrderNotFound
+	}
+
+	sellerView := order.UserID != actor.ID && !os.policy.Can(actor.Role, policy.OrderReadAny)
+	sellerID := 0
+	if sellerView {
+		sellerID = actor.ID
+	}
+	items, err := os.orderRepo.ListOrderItems(orderID, sellerID)
+	if err != nil {
+		return nil, err
+	}
+	fulfillments, err := os.orderRepo.ListFulfillments([]int{orderID})
+	if err != nil {
+		return nil, err
+	}
+	history, err := os.orderRepo.ListStatusHistory([]int{orderID})
+	if err != nil {
+		return nil, err
+	}
+
+	order.Items = items
+	order.Fulfillments = fulfillments[orderID]
+	order.History = history[orderID]
+	if sellerView {
+		own := make([]models.OrderFulfillment, 0, 1)
+		for _, f := range order.Fulfillments {
+			if f.SellerID == actor.ID {
+				own = append(own, f)
+			}
+		}
+		order.Fulfillments = own
+		order.History = historyVisibleToSeller(order.History, actor.ID)
+		order.TotalPrice = 0
+		for _, item := range items {
+			order.TotalPrice += item.LineTotal
+		}
+	}
+	return order, nil
+}
+
+// canViewOrder reports whether actor may see the order: the buyer who placed
+// it, a seller with items in it, or a role allowed to read any order.
+func (os *OrderService) canViewOrder(actor *models.User, order *models.Order) (bool, error) {
+	if order.UserID == actor.ID || os.policy.Can(actor.Role, policy.OrderReadAny) {
+		return true, nil
+	}
+	if !os.policy.Can(actor.Role, policy.OrderReadSeller) {
+		return false, nil
+	}
+	return os.orderRepo.OrderHasSellerItems(order.ID, actor.ID)
+}
+
+// historyVisibleToSeller keeps order-level changes and the seller's own
+// fulfillment changes, hiding other sellers' parts of the order.
+func historyVisibleToSeller(changes []models.OrderStatusChange, sellerID int) []models.OrderStatusChange {
+	visible := make([]models.OrderStatusChange, 0, len(changes))
+	for _, c := range changes {
+		if c.SellerID == 0 || c.SellerID == sellerID {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+// orderStatusRank orders the non-cancelled statuses by progress. The order as
+// a whole is as far along as its least advanced active part.
+var orderStatusRank = map[string]int{
+	models.OrderStatusPending:   0,
+	models.OrderStatusConfirmed: 1,
+	models.OrderStatusPacked:    2,
+	models.OrderStatusShipped:   3,
+	models.OrderStatusDelivered: 4,
+	models.OrderStatusRefunded:  5,
+}
+
+func deriveOrderStatus(fulfillments []models.OrderFulfillment) string {
+	derived := ""
+	for _, f := range fulfillments {
+		if f.Status == models.OrderStatusCancelled {
+			continue
+		}
+		if derived == "" || orderStatusRank[f.Status] < orderStatusRank[derived] {
+			derived = f.Status
+		}
+	}
+	if derived == "" {
+		return models.OrderStatusCancelled
+	}
+	return derived
+}
+
+// UpdateOrderStatus moves seller parts of an order to status. A seller always
+// acts on their own part. A role allowed to manage any order may pass sellerID
+// to target one part, or zero to move every part that is not cancelled.
+func (os *OrderService) UpdateOrderStatus(actorID, orderID, sellerID int, status, note string) error {
+	status = strings.ToLower(strings.TrimSpace(status))
+	if actorID <= 0 || orderID <= 0 || sellerID < 0 {
+		return ErrInvalidOrder
+	}
+	if !isKnownOrderStatus(status) {
+		return ErrInvalidStatus
+	}
+
+	actor, err := os.userRepo.GetUserByID(actorID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	order, err := os.orderRepo.GetOrderByID(orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+
+	if !os.policy.Can(actor.Role, policy.OrderStatusAny) {
+		if !os.policy.Can(actor.Role, policy.OrderStatusOwn) {
+			return ErrOrderForbidden
+		}
+		if sellerID != 0 && sellerID != actorID {
+			return ErrOrderForbidden
+		}
+		sellerID = actorID
+	}
+
+	byOrder, err := os.orderRepo.ListFulfillments([]int{orderID})
+	if err != nil {
+		return err
+	}
+	fulfillments := byOrder[orderID]
+
+	var transitions []repositories.FulfillmentTransition
+	for i, f := range fulfillments {
+		if sellerID != 0 && f.SellerID != sellerID {
+			continue
+		}
+		if sellerID == 0 && f.Status == models.OrderStatusCancelled {
+			continue
+		}
+		if !canTransitionOrder(f.Status, status) {
+			return ErrStatusTransition
+		}
+		transitions = append(transitions, repositories.FulfillmentTransition{
+			FulfillmentID: f.ID,
+			SellerID:      f.SellerID,
+			From:          f.Status,
+			To:            status,
+		})
+		fulfillments[i].Status = status
+	}
+	if len(transitions) == 0 {
+		if sellerID != 0 && !os.policy.Can(actor.Role, policy.OrderStatusAny) {
+			return ErrOrderForbidden
+		}
+		return ErrStatusTransition
+	}
+
+	err = os.orderRepo.ApplyFulfillmentTransitions(orderID, transitions, order.Status, deriveOrderStatus(fulfillments), actorID, strings.TrimSpace(note))
+	if err != nil {
+		if errors.Is(err, repositories.ErrStatusConflict) {
+			return ErrStatusTransition
+		}
+		return err
+	}
+	return nil
+}
+
+// CancelOrder cancels every part of a buyer's order. It is only allowed while
+// all parts that are not already cancelled are still pending or confirmed.
+func (os *OrderService) CancelOrder(buyerID, orderID int, reason string) error {
+	if buyerID <= 0 || orderID <= 0 {
+		return ErrInvalidOrder
+	}
+	reason = strings.TrimSpace(reason)
+
+	order, err := os.orderRepo.GetOrderByID(orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+	if order.UserID != buyerID {
+		return ErrOrderNotFound
+	}
+
+	byOrder, err := os.orderRepo.ListFulfillments([]int{orderID})
+	if err != nil {
+		return err
+	}
+	var transitions []repositories.FulfillmentTransition
+	for _, f := range byOrder[orderID] {
+		if f.Status == models.OrderStatusCancelled {
+			continue
+		}
+		if !buyerCancellableStatuses[f.Status] {
+			return ErrNotCancellable
+		}
+		transitions = append(transitions, repositories.FulfillmentTransition{
+			FulfillmentID: f.ID,
+			SellerID:      f.SellerID,
+			From:          f.Status,
+			To:            models.OrderStatusCancelled,
+		})
+	}
+	if len(transitions) == 0 {
+		return ErrNotCancellable
+	}
+
+	err = os.orderRepo.ApplyFulfillmentTransitions(orderID, transitions, order.Status, models.OrderStatusCancelled, buyerID, reason)
+	if err != nil {
+		if errors.Is(err, repositories.ErrStatusConflict) {
+			return ErrNotCancellable
+		}
+		return err
+	}
+	return nil
 }
 
 type ContactService struct {
 	contactRepo *repositories.ContactRepository
 	userRepo    *repositories.UserRepository
+	policy      *policy.Policy
 }
 
-func NewContactService(cr *repositories.ContactRepository, ur *repositories.UserRepository) *ContactService {
-	return &ContactService{contactRepo: cr, userRepo: ur}
+func NewContactService(cr *repositories.ContactRepository, ur *repositories.UserRepository, pol *policy.Policy) *ContactService {
+	return &ContactService{contactRepo: cr, userRepo: ur, policy: pol}
 }
 
 func (cs *ContactService) SendMessage(name, email, message string) error {
@@ -204,7 +849,7 @@ func (cs *ContactService) ListMessagesForAdmin(adminID int) ([]models.ContactMes
 		}
 		return nil, err
 	}
-	if admin.Role != "administrator" {
+	if !cs.policy.Can(admin.Role, policy.ContactRead) {
 		return nil, ErrAdminRequired
 	}
 
@@ -225,10 +870,31 @@ func (cs *ContactService) ListMessagesForAdmin(adminID int) ([]models.ContactMes
 
 type UserService struct {
 	userRepo *repositories.UserRepository
+	policy   *policy.Policy
 }
 
-func NewUserService(ur *repositories.UserRepository) *UserService {
-	return &UserService{userRepo: ur}
+func NewUserService(ur *repositories.UserRepository, pol *policy.Policy) *UserService {
+	return &UserService{userRepo: ur, policy: pol}
+}
+
+// CheckRoles fails when a role stored on some user is not defined by the
+// policy, so a policy file that drops a role in use is caught at startup
+// instead of locking those users out.
+func (us *UserService) CheckRoles() error {
+	roles, err := us.userRepo.ListRoles()
+	if err != nil {
+		return err
+	}
+	var missing []string
+	for _, role := range roles {
+		if !us.policy.HasRole(role) {
+			missing = append(missing, role)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("users have roles the policy does not define: %s", strings.Join(missing, ", "))
+	}
+	return nil
 }
 
 var (
@@ -251,16 +917,21 @@ func (us *UserService) Register(name, email, password, role string) (int, string
 	}
 
 	if role == "" {
-		role = "buyer"
+		role = policy.DefaultRole
 	}
-	if role != "buyer" && role != "seller" {
+	if !us.policy.CanSelfRegister(role) {
 		return 0, "", ErrInvalidRole
 	}
 
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return 0, "", err
+	}
+
 	user := models.User{
 		Name:         name,
 		Email:        email,
-		PasswordHash: password,
+		PasswordHash: passwordHash,
 		Role:         role,
 	}
 	id, err := us.userRepo.CreateUser(user)
@@ -280,9 +951,19 @@ func (us *UserService) Login(email, password string) (*models.User, error) {
 		return nil, ErrInvalidCredentials
 	}
 
-	if user.PasswordHash != password {
+	ok, needsRehash := verifyPassword(user.PasswordHash, password)
+	if !ok {
 		return nil, ErrInvalidCredentials
 	}
+	if needsRehash {
+		if passwordHash, err := hashPassword(password); err != nil {
+			log.Printf("password rehash for user %d failed: %v", user.ID, err)
+		} else if err := us.userRepo.UpdatePasswordHash(user.ID, passwordHash); err != nil {
+			log.Printf("password rehash for user %d failed: %v", user.ID, err)
+		} else {
+			user.PasswordHash = passwordHash
+		}
+	}
 
 	return user, nil
 }
@@ -290,3 +971,42 @@ func (us *UserService) Login(email, password string) (*models.User, error) {
 func (us *UserService) GetUserByID(id int) (*models.User, error) {
 	return us.userRepo.GetUserByID(id)
 }
+
+func (us *UserService) Permissions(role string) []policy.Permission {
+	return us.policy.Permissions(role)
+}
+
+const passwordHashCost = 12
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// verifyPassword reports whether password matches the stored hash and whether
+// the stored value should be replaced. Rows written before hashing was
+// introduced hold the plaintext password; those are compared in constant time
+// and flagged for rehash so they are upgraded on the next successful login.
+func verifyPassword(stored, password string) (ok bool, needsRehash bool) {
+	if stored == "" {
+		return false, false
+	}
+	if !isBcryptHash(stored) {
+		if subtle.ConstantTimeCompare([]byte(stored), []byte(password)) != 1 {
+			return false, false
+		}
+		return true, true
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err != nil || cost < passwordHashCost
+}
+
+func isBcryptHash(s string) bool {
+	return len(s) == 60 && (strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$"))
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+const (
+	defaultAlertPageSize = 50
+	maxAlertPageSize     = 200
+)
+
+var (
+	ErrStockAlertNotFound = errors.New("stock alert not found")
+	ErrInvalidAlertQuery  = errors.New("invalid alert query: status must be open or all; limit 1-200, offset >= 0")
+)
+
+// StockAlertService tells sellers which of their variants run low. Alerts
+// are opened and resolved by a background scan of the stock levels, so a
+// variant that drops under its product's reorder threshold after an order
+// shows up in the feed within one scan interval.
+type StockAlertService struct {
+	alertRepo *repositories.StockAlertRepository
+}
+
+func NewStockAlertService(ar *repositories.StockAlertRepository) *StockAlertService {
+	return &StockAlertService{alertRepo: ar}
+}
+
+// Feed returns one page of the alerts of sellerID's products, newest first;
+// a zero sellerID lists every seller's. status is "open" (the default) for
+// the alerts still standing or "all" to include resolved ones.
+func (as *StockAlertService) Feed(sellerID int, status string, limit, offset int) (*models.StockAlertFeed, error) {
+	var openOnly bool
+	switch status {
+	case "", "open":
+		openOnly = true
+	case "all":
+	default:
+		return nil, ErrInvalidAlertQuery
+	}
+	if limit == 0 {
+		limit = defaultAlertPageSize
+	}
+	if limit < 0 || limit > maxAlertPageSize || offset < 0 {
+		return nil, ErrInvalidAlertQuery
+	}
+	alerts, total, unread, err := as.alertRepo.ListAlerts(sellerID, openOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &models.StockAlertFeed{
+		Items:   alerts,
+		Unread:  unread,
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		HasMore: offset+len(alerts) < total,
+	}, nil
+}
+
+// MarkRead marks one of sellerID's alerts read.
+func (as *StockAlertService) MarkRead(id, sellerID int) error {
+	if id <= 0 {
+		return ErrStockAlertNotFound
+	}
+	found, err := as.alertRepo.MarkAlertRead(id, sellerID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrStockAlertNotFound
+	}
+	return nil
+}
+
+// MarkAllRead marks every unread alert of sellerID read and returns how many
+// there were.
+func (as *StockAlertService) MarkAllRead(sellerID int) (int64, error) {
+	return as.alertRepo.MarkAllAlertsRead(sellerID)
+}
+
+// StartScanner compares the stock of every variant with its product's
+// reorder threshold now and then every interval.
+func (as *StockAlertService) StartScanner(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			opened, resolved, err := as.alertRepo.ScanStockLevels(time.Now())
+			if err != nil {
+				log.Printf("Background: stock alert scan failed: %v", err)
+			} else if opened > 0 || resolved > 0 {
+				log.Printf("Background: stock alerts: %d opened, %d resolved", opened, resolved)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+const (
+	defaultMovementPageSize = 50
+	maxMovementPageSize     = 200
+	maxMovementReasonLen    = 500
+	maxLotNumberLen         = 64
+)
+
+var (
+	ErrInvalidStockMovement = errors.New("invalid stock movement: kind must be receipt, adjustment or spoilage, the quantity must follow the unit step and be positive (adjustments: non-zero), and adjustments and spoilage need a reason of at most 500 characters")
+	ErrInvalidMovementQuery = errors.New("invalid movement query: kind must be receipt, sale, cancellation, adjustment or spoilage; limit 1-200, offset >= 0, from before to")
+	ErrInvalidBatch         = errors.New("invalid batch: lot_number (at most 64 characters) and best_before (YYYY-MM-DD) name a new or existing lot for receipts only; other movements pick a batch by batch_id")
+	ErrBatchNotFound        = errors.New("batch not found")
+	ErrLotMismatch          = errors.New("this lot already exists with another best-before date")
+)
+
+// RecordStockMovement records a manual movement on a variant of a product:
+// a receipt adds m.Quantity, spoilage removes it and an adjustment applies
+// it signed. Sales and cancellations are recorded by orders only. A receipt
+// may name a lot (m.LotNumber, with m.BestBefore for a new one); any
+// movement may pick a batch by m.BatchID, which also gives the variant.
+// Otherwise the variant may be left out for products with a single variant.
+func (ps *ProductService) RecordStockMovement(productID int, m models.StockMovement) (*models.StockMovement, error) {
+	product, err := ps.getProduct(productID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ps.checkMovementBatch(productID, &m); err != nil {
+		return nil, err
+	}
+	variant, err := resolveVariant(product, m.VariantID)
+	if err != nil {
+		return nil, err
+	}
+	m.ProductID = productID
+	m.VariantID = variant.ID
+	m.Reason = strings.TrimSpace(m.Reason)
+	m.OrderID = 0
+
+	size := m.Quantity
+	if size < 0 {
+		size = -size
+	}
+	if size == 0 || !models.RuleForUnit(variant.Unit).OnStep(size) || len(m.Reason) > maxMovementReasonLen {
+		return nil, ErrInvalidStockMovement
+	}
+	switch m.Kind {
+	case models.StockMovementReceipt:
+		if m.Quantity < 0 {
+			return nil, ErrInvalidStockMovement
+		}
+	case models.StockMovementSpoilage:
+		if m.Quantity < 0 || m.Reason == "" {
+			return nil, ErrInvalidStockMovement
+		}
+		m.Quantity = -m.Quantity
+	case models.StockMovementAdjustment:
+		if m.Reason == "" {
+			return nil, ErrInvalidStockMovement
+		}
+	default:
+		return nil, ErrInvalidStockMovement
+	}
+
+	id, err := ps.productRepo.RecordStockMovement(m)
+	if err != nil {
+		switch {
+		case errors.Is(err, repositories.ErrInsufficientStock):
+			return nil, ErrInsufficientStock
+		case errors.Is(err, repositories.ErrLotMismatch):
+			return nil, ErrLotMismatch
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrVariantNotFound
+		}
+		return nil, err
+	}
+	return ps.productRepo.GetStockMovement(id)
+}
+
+// checkMovementBatch validates the batch fields of m. A batch given by ID
+// must belong to the product, and fills in or must match m.VariantID.
+func (ps *ProductService) checkMovementBatch(productID int, m *models.StockMovement) error {
+	m.LotNumber = strings.TrimSpace(m.LotNumber)
+	m.BestBefore = strings.TrimSpace(m.BestBefore)
+	if len(m.LotNumber) > maxLotNumberLen {
+		return ErrInvalidBatch
+	}
+	if m.BestBefore != "" {
+		if _, err := time.Parse("2006-01-02", m.BestBefore); err != nil || m.LotNumber == "" {
+			return ErrInvalidBatch
+		}
+	}
+	if m.LotNumber != "" && (m.Kind != models.StockMovementReceipt || m.BatchID != 0) {
+		return ErrInvalidBatch
+	}
+	if m.BatchID == 0 {
+		return nil
+	}
+	batch, err := ps.productRepo.GetBatch(m.BatchID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrBatchNotFound
+		}
+		return err
+	}
+	if batch.ProductID != productID || (m.VariantID != 0 && m.VariantID != batch.VariantID) {
+		return ErrBatchNotFound
+	}
+	m.VariantID = batch.VariantID
+	return nil
+}
+
+// StockMovements reports the movements of a product, newest first, with
+// the quantities of every matching movement added up by kind.
+func (ps *ProductService) StockMovements(q models.StockMovementQuery) (*models.StockMovementReport, error) {
+	if _, err := ps.getProduct(q.ProductID); err != nil {
+		return nil, err
+	}
+	switch q.Kind {
+	case "", models.StockMovementReceipt, models.StockMovementSale, models.StockMovementCancellation,
+		models.StockMovementAdjustment, models.StockMovementSpoilage:
+	default:
+		return nil, ErrInvalidMovementQuery
+	}
+	if q.Limit == 0 {
+		q.Limit = defaultMovementPageSize
+	}
+	if q.Limit < 0 || q.Limit > maxMovementPageSize || q.Offset < 0 {
+		return nil, ErrInvalidMovementQuery
+	}
+	if q.From != nil && q.To != nil && !q.From.Before(*q.To) {
+		return nil, ErrInvalidMovementQuery
+	}
+
+	movements, total, totals, err := ps.productRepo.ListStockMovements(q)
+	if err != nil {
+		return nil, err
+	}
+	var net models.Quantity
+	for _, sum := range totals {
+		net += sum
+	}
+	return &models.StockMovementReport{
+		ProductID: q.ProductID,
+		Items:     movements,
+		Totals:    totals,
+		Net:       net,
+		Total:     total,
+		Limit:     q.Limit,
+		Offset:    q.Offset,
+		HasMore:   q.Offset+len(movements) < total,
+	}, nil
+}
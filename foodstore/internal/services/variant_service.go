@@ -0,0 +1,167 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"foodstore/internal/models"
+	"foodstore/internal/repositories"
+)
+
+const maxVariantCodeLen = 64
+
+var (
+	ErrVariantNotFound = errors.New("variant not found")
+	ErrVariantRequired = errors.New("variant_id is required for products with several variants")
+	ErrInvalidVariant  = errors.New("invalid variant: price and stock must be >= 0, stock must follow the unit step, unit must be kg, piece or pack, and sku and barcode at most 64 characters without spaces")
+	ErrSKUTaken        = errors.New("sku is already used by another variant")
+	ErrBarcodeTaken    = errors.New("barcode is already used by another variant")
+	ErrLastVariant     = errors.New("a product needs at least one variant")
+)
+
+// resolveVariant picks the variant of product being bought. Without a
+// variantID only a product with a single variant can be bought.
+func resolveVariant(product *models.Product, variantID int) (*models.ProductVariant, error) {
+	if variantID <= 0 {
+		if len(product.Variants) != 1 {
+			return nil, ErrVariantRequired
+		}
+		return &product.Variants[0], nil
+	}
+	for i := range product.Variants {
+		if product.Variants[i].ID == variantID {
+			return &product.Variants[i], nil
+		}
+	}
+	return nil, ErrVariantNotFound
+}
+
+// ListVariants returns the variants of a product in position order.
+func (ps *ProductService) ListVariants(productID int) ([]models.ProductVariant, error) {
+	product, err := ps.getProduct(productID)
+	if err != nil {
+		return nil, err
+	}
+	return product.Variants, nil
+}
+
+// CreateVariant adds a variant to a product. An empty SKU is generated, and
+// the variant's stock is recorded as received by actorID.
+func (ps *ProductService) CreateVariant(productID int, v models.ProductVariant, actorID int) (*models.ProductVariant, error) {
+	product, err := ps.getProduct(productID)
+	if err != nil {
+		return nil, err
+	}
+	v.ID = 0
+	v.ProductID = productID
+	if err := ps.checkVariant(&v, product.SellerID); err != nil {
+		return nil, err
+	}
+	id, err := ps.productRepo.CreateVariant(v, actorID)
+	if err != nil {
+		return nil, err
+	}
+	return ps.productRepo.GetVariant(id)
+}
+
+// UpdateVariant updates a variant of a product. Its stock is kept; stock
+// changes are recorded with RecordStockMovement.
+func (ps *ProductService) UpdateVariant(productID int, v models.ProductVariant) (*models.ProductVariant, error) {
+	existing, err := ps.productRepo.GetVariant(v.ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrVariantNotFound
+		}
+		return nil, err
+	}
+	if existing.ProductID != productID {
+		return nil, ErrVariantNotFound
+	}
+	product, err := ps.getProduct(productID)
+	if err != nil {
+		return nil, err
+	}
+	v.ProductID = productID
+	if strings.TrimSpace(v.SKU) == "" {
+		v.SKU = existing.SKU
+	}
+	if err := ps.checkVariant(&v, product.SellerID); err != nil {
+		return nil, err
+	}
+	updated, err := ps.productRepo.UpdateVariant(v)
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, ErrVariantNotFound
+	}
+	return ps.productRepo.GetVariant(v.ID)
+}
+
+// DeleteVariant removes a variant; the last variant of a product cannot be
+// removed. Its remaining stock is written off as an adjustment by actorID.
+func (ps *ProductService) DeleteVariant(productID, variantID, actorID int) error {
+	deleted, err := ps.productRepo.DeleteVariant(productID, variantID, actorID)
+	if err != nil {
+		if errors.Is(err, repositories.ErrLastVariant) {
+			return ErrLastVariant
+		}
+		return err
+	}
+	if !deleted {
+		return ErrVariantNotFound
+	}
+	return nil
+}
+
+func (ps *ProductService) getProduct(productID int) (*models.Product, error) {
+	product, err := ps.productRepo.GetProductByID(productID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrProductNotFound
+		}
+		return nil, err
+	}
+	return product, nil
+}
+
+// checkVariant trims and validates v and checks that its SKU is not used by
+// another variant of sellerID and its barcode by any other variant.
+func (ps *ProductService) checkVariant(v *models.ProductVariant, sellerID int) error {
+	v.SKU = strings.TrimSpace(v.SKU)
+	v.Name = strings.TrimSpace(v.Name)
+	v.Barcode = strings.TrimSpace(v.Barcode)
+	switch v.Unit {
+	case models.UnitKg, models.UnitPiece, models.UnitPack:
+	default:
+		return ErrInvalidVariant
+	}
+	if v.Price < 0 || v.Stock < 0 || !models.RuleForUnit(v.Unit).OnStep(v.Stock) {
+		return ErrInvalidVariant
+	}
+	for _, code := range []string{v.SKU, v.Barcode} {
+		if len(code) > maxVariantCodeLen || strings.ContainsAny(code, " \t\r\n") {
+			return ErrInvalidVariant
+		}
+	}
+	if v.SKU != "" {
+		taken, err := ps.productRepo.VariantSKUExists(v.SKU, sellerID, v.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrSKUTaken
+		}
+	}
+	if v.Barcode != "" {
+		taken, err := ps.productRepo.VariantBarcodeExists(v.Barcode, v.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrBarcodeTaken
+		}
+	}
+	return nil
+}
@@ -4,10 +4,12 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"foodstore/config"
 	"foodstore/internal/handlers"
 	"foodstore/internal/middleware"
+	"foodstore/internal/policy"
 	"foodstore/internal/repositories"
 	"foodstore/internal/services"
 )
@@ -24,30 +26,109 @@ func main() {
 	}
 	defer db.Close()
 
+	pol, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("failed to load role policy: %v", err)
+	}
+
 	productRepo := repositories.NewProductRepository(db)
 	orderRepo := repositories.NewOrderRepository(db)
 	contactRepo := repositories.NewContactRepository(db)
 	userRepo := repositories.NewUserRepository(db)
+	sessionRepo := repositories.NewSessionRepository(db)
+	rateRepo := repositories.NewExchangeRateRepository(db)
+	cartRepo := repositories.NewCartRepository(db)
+	reservationRepo := repositories.NewReservationRepository(db)
+	idempotencyRepo := repositories.NewIdempotencyRepository(db)
+	categoryRepo := repositories.NewCategoryRepository(db)
+	stockAlertRepo := repositories.NewStockAlertRepository(db)
+
+	currencyService := services.NewCurrencyService(rateRepo, cfg.BaseCurrency)
+	categoryService := services.NewCategoryService(categoryRepo)
+	if err := categoryService.MapProductCategories(); err != nil {
+		log.Fatalf("failed to map product categories: %v", err)
+	}
+	productService := services.NewProductService(productRepo, currencyService, categoryService)
+	orderService := services.NewOrderService(orderRepo, productRepo, userRepo, currencyService, pol)
+	contactService := services.NewContactService(contactRepo, userRepo, pol)
+	userService := services.NewUserService(userRepo, pol)
+	if err := userService.CheckRoles(); err != nil {
+		log.Fatalf("role policy does not match stored users: %v", err)
+	}
+	idempotencyService := services.NewIdempotencyService(idempotencyRepo)
+	reservationService := services.NewReservationService(reservationRepo, cfg.ReservationTTL)
+	cartService := services.NewCartService(cartRepo, productRepo, reservationService, currencyService, orderService)
+	stockAlertService := services.NewStockAlertService(stockAlertRepo)
 
-	productService := services.NewProductService(productRepo)
-	orderService := services.NewOrderService(orderRepo, productRepo, userRepo)
-	contactService := services.NewContactService(contactRepo, userRepo)
-	userService := services.NewUserService(userRepo)
+	if cfg.SessionSecret == "" {
+		log.Printf("SESSION_SECRET is not set; using a random key, sessions will not survive a restart")
+	}
+	authService, err := services.NewAuthService(sessionRepo, cfg.SessionSecret, cfg.SessionTTL, cfg.SessionIdle)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authService.StartSessionCleanup(time.Hour)
+	reservationService.StartSweeper(time.Minute)
+	stockAlertService.StartScanner(time.Minute)
+	idempotencyService.StartCleanup(time.Hour)
 
-	ph := handlers.NewProductHandler(productService, userService, cfg.UploadDir)
-	oh := handlers.NewOrderHandler(orderService)
+	ph := handlers.NewProductHandler(productService, pol, cfg.UploadDir)
+	oh := handlers.NewOrderHandler(orderService, idempotencyService)
 	ch := handlers.NewContactHandler(contactService)
-	uh := handlers.NewUserHandler(userService)
+	uh := handlers.NewUserHandler(userService, authService, cartService)
+	cth := handlers.NewCartHandler(cartService)
+	xh := handlers.NewCurrencyHandler(currencyService)
+	cgh := handlers.NewCategoryHandler(categoryService)
+	sah := handlers.NewStockAlertHandler(stockAlertService, pol)
 
 	http.HandleFunc("/health", handlers.HealthHandler)
-	http.Handle("/products", middleware.RequireSeller(userService, http.HandlerFunc(ph.ListProducts)))
-	http.HandleFunc("/orders", oh.PlaceOrder)
-	http.Handle("/seller/orders", middleware.RequireSellerStrict(userService, http.HandlerFunc(oh.SellerOrders)))
+	http.Handle("/products", middleware.RequirePermissionForWrites(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.ListProducts)))
+	http.HandleFunc("GET /products/search", ph.SearchProducts)
+	http.HandleFunc("GET /products/{id}/variants", ph.ListVariants)
+	http.Handle("POST /products/{id}/variants", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.CreateVariant)))
+	http.Handle("PUT /products/{id}/variants/{variant_id}", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.UpdateVariant)))
+	http.Handle("DELETE /products/{id}/variants/{variant_id}", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.DeleteVariant)))
+	http.Handle("GET /products/{id}/stock-movements", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.StockMovements)))
+	http.Handle("POST /products/{id}/stock-movements", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.RecordStockMovement)))
+	http.Handle("GET /products/{id}/batches", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.ListBatches)))
+	http.Handle("POST /products/{id}/restore", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.RestoreProduct)))
+	http.Handle("DELETE /admin/products/{id}", middleware.RequirePermission(pol, policy.ProductWriteAny, http.HandlerFunc(ph.PurgeProduct)))
+	http.HandleFunc("GET /categories", cgh.ListCategories)
+	http.Handle("POST /admin/categories", middleware.RequirePermission(pol, policy.CategoryManage, http.HandlerFunc(cgh.CreateCategory)))
+	http.Handle("PUT /admin/categories/{id}", middleware.RequirePermission(pol, policy.CategoryManage, http.HandlerFunc(cgh.UpdateCategory)))
+	http.Handle("DELETE /admin/categories/{id}", middleware.RequirePermission(pol, policy.CategoryManage, http.HandlerFunc(cgh.DeleteCategory)))
+	http.Handle("/orders", middleware.RequireAuth(http.HandlerFunc(oh.PlaceOrder)))
+	http.HandleFunc("GET /orders/{id}", oh.GetOrder)
+	http.HandleFunc("POST /orders/{id}/status", oh.UpdateStatus)
+	http.HandleFunc("POST /orders/{id}/cancel", oh.CancelOrder)
+	http.HandleFunc("GET /orders/{id}/history", oh.OrderHistory)
+	http.Handle("GET /admin/orders", middleware.RequirePermission(pol, policy.OrderReadAny, http.HandlerFunc(oh.AdminUserOrders)))
+	http.Handle("/seller/orders", middleware.RequirePermission(pol, policy.OrderReadSeller, http.HandlerFunc(oh.SellerOrders)))
+	http.Handle("GET /seller/alerts", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(sah.ListAlerts)))
+	http.Handle("POST /seller/alerts/read", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(sah.MarkAllRead)))
+	http.Handle("POST /seller/alerts/{id}/read", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(sah.MarkRead)))
+	http.Handle("GET /seller/expiring", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.ExpiringStock)))
+	http.Handle("POST /seller/products/import", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.ImportProducts)))
+	http.Handle("GET /seller/products/export", middleware.RequirePermission(pol, policy.ProductWriteOwn, http.HandlerFunc(ph.ExportProducts)))
+	http.HandleFunc("GET /cart", cth.GetCart)
+	http.HandleFunc("DELETE /cart", cth.ClearCart)
+	http.HandleFunc("POST /cart/items", cth.AddItem)
+	http.HandleFunc("PUT /cart/items/{variant_id}", cth.UpdateItem)
+	http.HandleFunc("DELETE /cart/items/{variant_id}", cth.RemoveItem)
+	http.Handle("POST /cart/reservation", middleware.RequireAuth(http.HandlerFunc(cth.Reserve)))
+	http.Handle("DELETE /cart/reservation", middleware.RequireAuth(http.HandlerFunc(cth.ReleaseReservation)))
+	http.Handle("POST /cart/checkout", middleware.RequireAuth(http.HandlerFunc(cth.Checkout)))
+	http.HandleFunc("GET /exchange-rates", xh.ListRates)
+	http.Handle("POST /admin/exchange-rates", middleware.RequirePermission(pol, policy.RatesManage, http.HandlerFunc(xh.SetRate)))
+	http.Handle("DELETE /admin/exchange-rates/{currency}", middleware.RequirePermission(pol, policy.RatesManage, http.HandlerFunc(xh.DeleteRate)))
 	http.HandleFunc("/contact/messages", ch.ListMessagesForAdmin)
 	http.HandleFunc("/contact", ch.HandleContact)
 
 	http.HandleFunc("/api/register", uh.Register)
 	http.HandleFunc("/api/login", uh.Login)
+	http.HandleFunc("/api/logout", uh.Logout)
+	http.HandleFunc("/api/logout/all", uh.LogoutAll)
+	http.Handle("/api/admin/sessions/revoke", middleware.RequirePermission(pol, policy.SessionRevokeAny, http.HandlerFunc(uh.RevokeUserSessions)))
 	http.HandleFunc("/api/profile", uh.GetProfile)
 
 	http.Handle("/styles/", http.StripPrefix("/styles/", http.FileServer(http.Dir("frontend/styles"))))
@@ -57,7 +138,7 @@ func main() {
 	http.HandleFunc("/ui/products", handlers.ProductsPage)
 	http.HandleFunc("/ui/seller/products", handlers.SellerProductsPage)
 	http.HandleFunc("/ui/seller/orders", handlers.SellerOrdersPage)
-	http.HandleFunc("/ui/orders", handlers.OrdersPage)
+	http.HandleFunc("/ui/orders", handlers.OrdersPage(cfg.BaseCurrency))
 	http.HandleFunc("/ui/cart", handlers.CartPage)
 	http.HandleFunc("/ui/login", handlers.LoginPage)
 	http.HandleFunc("/ui/register", handlers.RegisterPage)
@@ -66,5 +147,5 @@ func main() {
 
 	log.Printf("Server running on %s", cfg.ServerAddress)
 	log.Printf("Uploads served from %s", cfg.UploadDir)
-	log.Fatal(http.ListenAndServe(cfg.ServerAddress, middleware.Logging(http.DefaultServeMux)))
+	log.Fatal(http.ListenAndServe(cfg.ServerAddress, middleware.Logging(middleware.Authenticate(authService, userService, http.DefaultServeMux))))
 }
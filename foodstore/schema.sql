@@ -3,7 +3,7 @@ CREATE TABLE IF NOT EXISTS users (
   name TEXT NOT NULL,
   email TEXT NOT NULL UNIQUE,
   password_hash TEXT NOT NULL,
-  role TEXT NOT NULL DEFAULT 'buyer' CHECK (role IN ('buyer', 'seller', 'administrator')),
+  role TEXT NOT NULL DEFAULT 'buyer',
   created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
@@ -14,7 +14,7 @@ CREATE TABLE IF NOT EXISTS products (
   description TEXT NOT NULL,
   image_url TEXT NOT NULL DEFAULT '',
   price NUMERIC(12,2) NOT NULL,
-  stock INTEGER NOT NULL DEFAULT 0,
+  stock NUMERIC(12,3) NOT NULL DEFAULT 0,
   category TEXT NOT NULL,
   unit TEXT NOT NULL DEFAULT 'piece',
   created_at TIMESTAMP NOT NULL DEFAULT NOW()
@@ -27,6 +27,28 @@ ADD COLUMN IF NOT EXISTS seller_id INTEGER;
 ALTER TABLE products
 ADD COLUMN IF NOT EXISTS unit TEXT;
 
+-- Sellable versions of a product ("1 L", "2 L"), each with its own SKU,
+-- price, stock and unit. products.price, stock and unit summarise them:
+-- the lowest price, the total stock and the first variant's unit. SKUs are
+-- unique per seller; seller_id is the product's.
+CREATE TABLE IF NOT EXISTS product_variants (
+  id SERIAL PRIMARY KEY,
+  product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  seller_id INTEGER REFERENCES users(id),
+  sku TEXT NOT NULL,
+  name TEXT NOT NULL DEFAULT '',
+  price NUMERIC(12,2) NOT NULL CHECK (price >= 0),
+  stock NUMERIC(12,3) NOT NULL DEFAULT 0 CHECK (stock >= 0),
+  unit TEXT NOT NULL DEFAULT 'piece',
+  barcode TEXT,
+  position INTEGER NOT NULL DEFAULT 0,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants(product_id, position);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_barcode ON product_variants(barcode) WHERE barcode IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_product_variants_seller_sku ON product_variants(COALESCE(seller_id, 0), sku);
+
 CREATE TABLE IF NOT EXISTS orders (
   id SERIAL PRIMARY KEY,
   user_id INTEGER NOT NULL REFERENCES users(id),
@@ -42,11 +64,37 @@ CREATE TABLE IF NOT EXISTS order_items (
   id SERIAL PRIMARY KEY,
   order_id INTEGER NOT NULL REFERENCES orders(id),
   product_id INTEGER NOT NULL REFERENCES products(id),
-  quantity INTEGER NOT NULL,
+  quantity NUMERIC(12,3) NOT NULL,
   unit_price NUMERIC(12,2) NOT NULL,
   line_total NUMERIC(12,2) NOT NULL
 );
 
+-- One row per seller taking part in an order; each part has its own status.
+CREATE TABLE IF NOT EXISTS order_fulfillments (
+  id SERIAL PRIMARY KEY,
+  order_id INTEGER NOT NULL REFERENCES orders(id),
+  seller_id INTEGER REFERENCES users(id),
+  status TEXT NOT NULL DEFAULT 'pending',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_order_fulfillments_order_seller ON order_fulfillments(order_id, COALESCE(seller_id, 0));
+CREATE INDEX IF NOT EXISTS idx_order_fulfillments_seller_id ON order_fulfillments(seller_id);
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+  id SERIAL PRIMARY KEY,
+  order_id INTEGER NOT NULL REFERENCES orders(id),
+  seller_id INTEGER REFERENCES users(id),
+  from_status TEXT NOT NULL DEFAULT '',
+  to_status TEXT NOT NULL,
+  actor_id INTEGER REFERENCES users(id),
+  note TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_status_history_order_id ON order_status_history(order_id);
+
 CREATE TABLE IF NOT EXISTS contact_messages (
   id SERIAL PRIMARY KEY,
   user_id INTEGER,
@@ -58,15 +106,168 @@ CREATE TABLE IF NOT EXISTS contact_messages (
   created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+CREATE TABLE IF NOT EXISTS sessions (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+
+-- Server-side carts: one per user, or per anonymous cart token (hashed)
+-- until the visitor logs in and the cart is merged into theirs.
+CREATE TABLE IF NOT EXISTS carts (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+  token_hash TEXT UNIQUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- added_price is the base-currency price when the line was added, used to
+-- flag price changes when the cart is read.
+CREATE TABLE IF NOT EXISTS cart_items (
+  id SERIAL PRIMARY KEY,
+  cart_id INTEGER NOT NULL REFERENCES carts(id) ON DELETE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+  quantity NUMERIC(12,3) NOT NULL CHECK (quantity > 0),
+  added_price NUMERIC(12,2) NOT NULL,
+  added_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Units of the base currency (BASE_CURRENCY) per unit of another currency.
+CREATE TABLE IF NOT EXISTS exchange_rates (
+  currency TEXT PRIMARY KEY,
+  rate NUMERIC(18,8) NOT NULL CHECK (rate > 0),
+  updated_by INTEGER REFERENCES users(id),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Application settings. 'base_currency' records the BASE_CURRENCY the
+-- database was set up with; the application refuses to start under another.
+CREATE TABLE IF NOT EXISTS settings (
+  key TEXT PRIMARY KEY,
+  value TEXT NOT NULL
+);
+
+-- Category taxonomy. Slugs are unique lower-case identifiers; parent_id
+-- builds the tree. products.category keeps the category name for search.
+CREATE TABLE IF NOT EXISTS categories (
+  id SERIAL PRIMARY KEY,
+  parent_id INTEGER REFERENCES categories(id),
+  name TEXT NOT NULL,
+  slug TEXT NOT NULL UNIQUE,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories(parent_id);
+
+-- Checkout holds: reserved quantities are subtracted from the available
+-- stock of other checkouts until expires_at. Holds belong to the checkout of
+-- one login session, so a user's sessions keep separate holds.
+-- products.stock stays the on-hand quantity; a hold is released when the
+-- order is placed or it expires.
+CREATE TABLE IF NOT EXISTS stock_reservations (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  session_id INTEGER NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+  quantity NUMERIC(12,3) NOT NULL CHECK (quantity > 0),
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_product_id ON stock_reservations(product_id, expires_at);
+
+-- Idempotency-Key values of POST /orders, per user. request_hash is the
+-- SHA-256 of the body; order_id is set in the transaction that places the
+-- order, and the response is filled in after it.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  idempotency_key TEXT NOT NULL,
+  request_hash TEXT NOT NULL,
+  status_code INTEGER,
+  response_body TEXT,
+  order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  completed_at TIMESTAMPTZ,
+  PRIMARY KEY (user_id, idempotency_key)
+);
+
+-- Append-only stock ledger. Variant stock only changes through a movement:
+-- quantity is the signed change and stock_after the stock it left, so the
+-- movements of a variant add up to its stock. Rows are never updated.
+CREATE TABLE IF NOT EXISTS stock_movements (
+  id SERIAL PRIMARY KEY,
+  product_id INTEGER REFERENCES products(id) ON DELETE SET NULL,
+  variant_id INTEGER REFERENCES product_variants(id) ON DELETE SET NULL,
+  variant_sku TEXT NOT NULL DEFAULT '',
+  kind TEXT NOT NULL CHECK (kind IN ('receipt', 'sale', 'cancellation', 'adjustment', 'spoilage')),
+  quantity NUMERIC(12,3) NOT NULL CHECK (quantity <> 0),
+  stock_after NUMERIC(12,3) NOT NULL,
+  actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+  order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product_id ON stock_movements(product_id, created_at, id);
+
+-- Low-stock and out-of-stock notices for sellers, opened and resolved by a
+-- background scan of variant stock against products.reorder_threshold. A
+-- variant has at most one open alert of each kind.
+CREATE TABLE IF NOT EXISTS stock_alerts (
+  id SERIAL PRIMARY KEY,
+  seller_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+  product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('low_stock', 'out_of_stock')),
+  stock NUMERIC(12,3) NOT NULL,
+  threshold NUMERIC(12,3) NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  read_at TIMESTAMP,
+  resolved_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_alerts_open ON stock_alerts(variant_id, kind) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_stock_alerts_seller_id ON stock_alerts(seller_id, created_at);
+
+-- Variant stock is held in batches: units received together under one lot
+-- number and best-before date. The batches of a variant add up to its
+-- stock; the batch without lot number ('') holds stock of no particular lot
+-- and has no best-before date. Sales take the first-expiring batches first.
+CREATE TABLE IF NOT EXISTS stock_batches (
+  id SERIAL PRIMARY KEY,
+  product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  variant_id INTEGER NOT NULL REFERENCES product_variants(id) ON DELETE CASCADE,
+  lot_number TEXT NOT NULL DEFAULT '',
+  best_before DATE,
+  quantity NUMERIC(12,3) NOT NULL DEFAULT 0 CHECK (quantity >= 0),
+  received_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  UNIQUE (variant_id, lot_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_batches_product_id ON stock_batches(product_id, best_before);
+
 -- Compatibility upgrades for existing databases
 ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS password_hash TEXT;
 ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS role TEXT;
 ALTER TABLE IF EXISTS users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP;
 
 UPDATE users SET password_hash = '' WHERE password_hash IS NULL;
-UPDATE users SET role = 'buyer' WHERE role IS NULL OR role = '' OR role NOT IN ('buyer', 'seller', 'administrator');
+-- Valid roles are defined by the application role policy, not a CHECK constraint.
+ALTER TABLE IF EXISTS users DROP CONSTRAINT IF EXISTS users_role_check;
+UPDATE users SET role = 'buyer' WHERE role IS NULL OR role = '';
 UPDATE users SET created_at = NOW() WHERE created_at IS NULL;
 
+-- Seeded administrator password is stored as a bcrypt hash of 'admin123'.
 DO $$
 BEGIN
   IF NOT EXISTS (SELECT 1 FROM users WHERE role = 'administrator') THEN
@@ -74,11 +275,11 @@ BEGIN
       UPDATE users
       SET role = 'administrator',
           name = COALESCE(NULLIF(name, ''), 'Administrator'),
-          password_hash = COALESCE(NULLIF(password_hash, ''), 'admin123')
+          password_hash = COALESCE(NULLIF(password_hash, ''), '$2a$12$wI1gJ62FDlK/yobVdrbFH.g9SJdU/8U2GgH0OKHcVcfBk8357t5Ei')
       WHERE email = 'admin@foodstore.local';
     ELSE
       INSERT INTO users (name, email, password_hash, role, created_at)
-      VALUES ('Administrator', 'admin@foodstore.local', 'admin123', 'administrator', NOW());
+      VALUES ('Administrator', 'admin@foodstore.local', '$2a$12$wI1gJ62FDlK/yobVdrbFH.g9SJdU/8U2GgH0OKHcVcfBk8357t5Ei', 'administrator', NOW());
     END IF;
   END IF;
 END
@@ -96,16 +297,82 @@ UPDATE products SET unit = 'piece' WHERE unit IS NULL OR unit = '';
 ALTER TABLE IF EXISTS products ALTER COLUMN unit SET DEFAULT 'piece';
 ALTER TABLE IF EXISTS products ALTER COLUMN unit SET NOT NULL;
 
+-- Quantities are fractional for goods sold by weight (0.5 kg).
+ALTER TABLE IF EXISTS products ALTER COLUMN stock TYPE NUMERIC(12,3);
+ALTER TABLE IF EXISTS order_items ALTER COLUMN quantity TYPE NUMERIC(12,3);
+
+-- Prices carry a currency code; orders record the currency and the rate
+-- against the base currency they were placed in. The application fills
+-- missing codes with BASE_CURRENCY on startup; 'KZT' is the default.
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS currency TEXT;
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS currency TEXT;
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(18,8);
+UPDATE products SET currency = 'KZT' WHERE currency IS NULL OR currency = '';
+UPDATE orders SET currency = 'KZT' WHERE currency IS NULL OR currency = '';
+UPDATE orders SET exchange_rate = 1 WHERE exchange_rate IS NULL;
+ALTER TABLE IF EXISTS orders ALTER COLUMN exchange_rate SET DEFAULT 1;
+ALTER TABLE IF EXISTS products ALTER COLUMN currency SET NOT NULL;
+ALTER TABLE IF EXISTS orders ALTER COLUMN currency SET NOT NULL;
+ALTER TABLE IF EXISTS orders ALTER COLUMN exchange_rate SET NOT NULL;
+
+-- Variants at or below their product's reorder threshold raise a low-stock
+-- alert; 0 disables it.
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS reorder_threshold NUMERIC(12,3) NOT NULL DEFAULT 0 CHECK (reorder_threshold >= 0);
+
+-- Near its best-before date a batch is first sold at expiry_discount_percent
+-- off (expiry_discount_days before the date) and then taken off sale
+-- (expiry_hide_days before it; 0 hides it once the date has passed).
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS expiry_hide_days INTEGER NOT NULL DEFAULT 0 CHECK (expiry_hide_days >= 0);
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS expiry_discount_days INTEGER NOT NULL DEFAULT 0 CHECK (expiry_discount_days >= 0);
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS expiry_discount_percent INTEGER NOT NULL DEFAULT 0 CHECK (expiry_discount_percent BETWEEN 0 AND 90);
+
+-- Movements name the batch they changed; lot_number is kept when the batch
+-- is deleted.
+ALTER TABLE IF EXISTS stock_movements ADD COLUMN IF NOT EXISTS batch_id INTEGER REFERENCES stock_batches(id) ON DELETE SET NULL;
+ALTER TABLE IF EXISTS stock_movements ADD COLUMN IF NOT EXISTS lot_number TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_stock_movements_order_id ON stock_movements(order_id) WHERE order_id IS NOT NULL;
+
+-- Deleting a product archives it: it leaves the catalog and is no longer
+-- sold, but orders still resolve it. Only unreferenced products are purged.
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id);
+
+-- Products reference a category. The application maps existing free-text
+-- categories on startup, by the same slug it gives new categories:
+-- spellings that only differ in case, spacing or punctuation end up in one
+-- category, whose name the products take.
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id);
+CREATE INDEX IF NOT EXISTS idx_products_category_id ON products(category_id);
+
+-- Full-text search over name (weight A), category (B) and description (C),
+-- indexed with both the English and the Russian stemmer.
+ALTER TABLE IF EXISTS products ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+  setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+  setweight(to_tsvector('russian', COALESCE(name, '')), 'A') ||
+  setweight(to_tsvector('english', COALESCE(category, '')), 'B') ||
+  setweight(to_tsvector('russian', COALESCE(category, '')), 'B') ||
+  setweight(to_tsvector('english', COALESCE(description, '')), 'C') ||
+  setweight(to_tsvector('russian', COALESCE(description, '')), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector);
+
 ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS status TEXT;
 ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS delivery_address TEXT;
 ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS phone_number TEXT;
 ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS comment TEXT;
 ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS created_at TIMESTAMP;
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS cancelled_by INTEGER REFERENCES users(id);
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP;
+ALTER TABLE IF EXISTS orders ADD COLUMN IF NOT EXISTS cancel_reason TEXT;
 UPDATE orders SET status = 'pending' WHERE status IS NULL OR status = '';
 UPDATE orders SET delivery_address = '' WHERE delivery_address IS NULL;
 UPDATE orders SET phone_number = '' WHERE phone_number IS NULL;
 UPDATE orders SET comment = '' WHERE comment IS NULL;
 UPDATE orders SET created_at = NOW() WHERE created_at IS NULL;
+INSERT INTO order_status_history (order_id, from_status, to_status, note, created_at)
+SELECT o.id, '', o.status, 'recorded before status history existed', o.created_at
+FROM orders o
+WHERE NOT EXISTS (SELECT 1 FROM order_status_history h WHERE h.order_id = o.id);
 ALTER TABLE IF EXISTS orders ALTER COLUMN status SET DEFAULT 'pending';
 ALTER TABLE IF EXISTS orders ALTER COLUMN delivery_address SET DEFAULT '';
 ALTER TABLE IF EXISTS orders ALTER COLUMN phone_number SET DEFAULT '';
@@ -121,9 +388,87 @@ ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS unit_price NUMERIC(12
 ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS line_total NUMERIC(12,2);
 UPDATE order_items SET unit_price = 0 WHERE unit_price IS NULL;
 UPDATE order_items SET line_total = 0 WHERE line_total IS NULL;
+
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS fulfillment_id INTEGER REFERENCES order_fulfillments(id);
+ALTER TABLE IF EXISTS order_status_history ADD COLUMN IF NOT EXISTS seller_id INTEGER REFERENCES users(id);
+INSERT INTO order_fulfillments (order_id, seller_id, status, created_at, updated_at)
+SELECT DISTINCT oi.order_id, p.seller_id, o.status, o.created_at, o.created_at
+FROM order_items oi
+JOIN orders o ON o.id = oi.order_id
+JOIN products p ON p.id = oi.product_id
+WHERE oi.fulfillment_id IS NULL
+ON CONFLICT DO NOTHING;
+UPDATE order_items oi
+SET fulfillment_id = f.id
+FROM products p, order_fulfillments f
+WHERE oi.fulfillment_id IS NULL
+  AND p.id = oi.product_id
+  AND f.order_id = oi.order_id
+  AND f.seller_id IS NOT DISTINCT FROM p.seller_id;
+
+-- Product details as they were when the order was placed.
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS product_name TEXT;
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS product_unit TEXT;
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS product_image_url TEXT;
+UPDATE order_items oi
+SET product_name = p.name, product_unit = p.unit, product_image_url = p.image_url
+FROM products p
+WHERE oi.product_name IS NULL AND p.id = oi.product_id;
 ALTER TABLE IF EXISTS order_items ALTER COLUMN unit_price SET NOT NULL;
 ALTER TABLE IF EXISTS order_items ALTER COLUMN line_total SET NOT NULL;
 
+-- Order lines reference the variant sold, with its SKU and name as they
+-- were at the time. Databases from before variants get one default variant
+-- per product ('P' || product id, with a random suffix when the seller
+-- already uses that SKU) that existing lines point at.
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE SET NULL;
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS variant_sku TEXT;
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS variant_name TEXT;
+-- Lines sold from near-expiry batches record the discount they got.
+ALTER TABLE IF EXISTS order_items ADD COLUMN IF NOT EXISTS discount_percent INTEGER NOT NULL DEFAULT 0;
+INSERT INTO product_variants (product_id, seller_id, sku, price, stock, unit, created_at)
+SELECT p.id, p.seller_id,
+  CASE WHEN EXISTS (
+    SELECT 1 FROM product_variants t
+    WHERE COALESCE(t.seller_id, 0) = COALESCE(p.seller_id, 0) AND t.sku = 'P' || p.id
+  ) THEN 'P' || p.id || '-' || substr(md5(random()::text), 1, 8) ELSE 'P' || p.id END,
+  p.price, GREATEST(p.stock, 0), COALESCE(NULLIF(p.unit, ''), 'piece'), p.created_at
+FROM products p
+WHERE NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id);
+UPDATE order_items oi
+SET variant_id = v.id, variant_sku = v.sku, variant_name = v.name
+FROM product_variants v
+WHERE oi.variant_sku IS NULL AND v.id = (SELECT MIN(d.id) FROM product_variants d WHERE d.product_id = oi.product_id);
+
+-- Stock on hand from before the ledger is recorded as an opening adjustment.
+INSERT INTO stock_movements (product_id, variant_id, variant_sku, kind, quantity, stock_after, reason, created_at)
+SELECT v.product_id, v.id, v.sku, 'adjustment', v.stock, v.stock, 'opening balance', NOW()
+FROM product_variants v
+WHERE v.stock > 0 AND NOT EXISTS (SELECT 1 FROM stock_movements m WHERE m.variant_id = v.id);
+
+-- Stock from before batches is put into a batch without lot or expiry.
+INSERT INTO stock_batches (product_id, variant_id, lot_number, best_before, quantity, received_at)
+SELECT v.product_id, v.id, '', NULL, v.stock, NOW()
+FROM product_variants v
+WHERE v.stock > 0 AND NOT EXISTS (SELECT 1 FROM stock_batches b WHERE b.variant_id = v.id);
+
+-- Cart lines and checkout holds are kept per variant.
+ALTER TABLE IF EXISTS cart_items ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE CASCADE;
+UPDATE cart_items ci
+SET variant_id = v.id
+FROM product_variants v
+WHERE ci.variant_id IS NULL AND v.id = (SELECT MIN(d.id) FROM product_variants d WHERE d.product_id = ci.product_id);
+DELETE FROM cart_items WHERE variant_id IS NULL;
+ALTER TABLE IF EXISTS cart_items DROP CONSTRAINT IF EXISTS cart_items_cart_id_product_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_cart_items_cart_variant ON cart_items(cart_id, variant_id);
+ALTER TABLE IF EXISTS cart_items ALTER COLUMN variant_id SET NOT NULL;
+ALTER TABLE IF EXISTS stock_reservations ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id) ON DELETE CASCADE;
+DELETE FROM stock_reservations WHERE variant_id IS NULL;
+ALTER TABLE IF EXISTS stock_reservations DROP CONSTRAINT IF EXISTS stock_reservations_user_id_product_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_stock_reservations_session_variant ON stock_reservations(session_id, variant_id);
+CREATE INDEX IF NOT EXISTS idx_stock_reservations_variant_id ON stock_reservations(variant_id, expires_at);
+ALTER TABLE IF EXISTS stock_reservations ALTER COLUMN variant_id SET NOT NULL;
+
 ALTER TABLE IF EXISTS contact_messages ADD COLUMN IF NOT EXISTS subject TEXT;
 ALTER TABLE IF EXISTS contact_messages ADD COLUMN IF NOT EXISTS status TEXT;
 ALTER TABLE IF EXISTS contact_messages ADD COLUMN IF NOT EXISTS created_at TIMESTAMP;